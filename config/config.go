@@ -2,9 +2,13 @@ package config
 
 import (
 	_ "embed"
+	"fmt"
 	"github.com/SGE-AI/sge-bot/logger"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //go:embed system.txt
@@ -12,9 +16,45 @@ var systemPrompt string
 
 const (
 	CustomInstructionsReplacement = "{{custom_instructions}}"
+	UserInstructionsReplacement   = "{{user_instructions}}"
+
+	OpenAIAPITypeOpenAI = "openai"
+	OpenAIAPITypeAzure  = "azure"
+
+	DefaultAzureOpenAIAPIVersion = "2024-10-21"
+
+	// DefaultOpenAISummaryModel - OpenAIのAPIを利用する場合に、古い会話の要約に利用する安価なモデル
+	DefaultOpenAISummaryModel = "gpt-4o-mini"
+
+	SlackTransportSocket = "socket"
+	SlackTransportHTTP   = "http"
+
+	QuotaScopeUser    = "user"
+	QuotaScopeChannel = "channel"
+	QuotaScopeGlobal  = "global"
+
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodMonthly = "monthly"
+
+	QuotaMetricTokens = "tokens"
+	QuotaMetricCost   = "usd"
 )
 
 type (
+	// ModelPrice - モデルの料金 (100万トークンあたりのUSD)
+	ModelPrice struct {
+		Input  float64
+		Output float64
+	}
+
+	// Quota - 利用上限 (例: user.daily.tokens=200000 は、ユーザーごとに一日20万トークンまで)
+	Quota struct {
+		Scope  string
+		Period string
+		Metric string
+		Limit  float64
+	}
+
 	Config interface {
 		SetBotUserID(botUserID string)
 		BotUserID() string
@@ -23,11 +63,36 @@ type (
 		OpenAIOrganizationID() string
 		SlackBotToken() string
 		SlackAppLevelToken() string
+		SlackTransport() string
+		SlackSigningSecret() string
 		OpenAIModel() string
-		SystemPrompt(customInstructions string) string
+		OpenAIFallbackModels() []string
+		AllowedModels() []string
+		OpenAISummaryModel() string
+		MaxPromptTokens() int
+		CompletionReserveTokens() int
+		ModelContextWindows() map[string]int
+		ModelPrices() map[string]ModelPrice
+		Quotas() []Quota
+		QuotaLocation() *time.Location
+		AdminUserIDs() []string
+		MaxConcurrentRequests() int
+		MaxConcurrentRequestsPerUser() int
+		OpenAIMaxRetries() int
+		OpenAIRetryMaxWait() time.Duration
+		OpenAIAPIType() string
+		ToolsEnabled() bool
+		MaxFileTokens() int
+		MaxAttachmentTokens() int
+		OpenAIBaseURL() string
+		AzureOpenAIEndpoint() string
+		AzureOpenAIAPIVersion() string
+		AzureOpenAIDeployments() map[string]string
+		SystemPrompt(customInstructions string, userInstructions string) string
 		GoogleApplicationCredentialsJSON() string
 		GoogleServiceAccountEmail() string
 		SpreadSheetID() string
+		DataDir() string
 	}
 
 	config struct {
@@ -36,7 +101,32 @@ type (
 		openAIOrganizationID string
 		slackBotToken        string
 		slackAppLevelToken   string
+		slackTransport       string
+		slackSigningSecret   string
 		openAIModel          string
+		openAIFallbackModels []string
+		allowedModels        []string
+		openAISummaryModel   string
+		maxPromptTokens      int
+		completionReserve    int
+		contextWindows       map[string]int
+		modelPrices          map[string]ModelPrice
+		quotas               []Quota
+		quotaLocation        *time.Location
+		adminUserIDs         []string
+		maxConcurrent        int
+		maxConcurrentPerUser int
+		openAIMaxRetries     int
+		openAIRetryMaxWait   time.Duration
+		openAIAPIType        string
+		toolsEnabled         bool
+		maxFileTokens        int
+		maxAttachmentTokens  int
+		openAIBaseURL        string
+		azureEndpoint        string
+		azureAPIVersion      string
+		azureDeployments     map[string]string
+		dataDir              string
 		botUserID            string
 	}
 )
@@ -49,12 +139,23 @@ func (c *config) BotUserID() string {
 	return c.botUserID
 }
 
-func (c *config) SystemPrompt(customInstructions string) string {
+// SystemPrompt - 全体のシステムメッセージに、チャンネルの指示とユーザーの個人設定による指示を埋め込みます
+// 指示が矛盾する場合は 全体 < チャンネル < ユーザー の順に、後のものを優先させます (system.txtに記載)
+func (c *config) SystemPrompt(customInstructions string, userInstructions string) string {
 	if customInstructions == "" {
 		customInstructions = "no custom instructions"
 	}
+	if userInstructions == "" {
+		userInstructions = "no user instructions"
+	}
 
-	return strings.Replace(systemPrompt, CustomInstructionsReplacement, customInstructions, 1)
+	prompt := strings.Replace(systemPrompt, CustomInstructionsReplacement, escapeCodeFence(customInstructions), 1)
+	return strings.Replace(prompt, UserInstructionsReplacement, escapeCodeFence(userInstructions), 1)
+}
+
+// escapeCodeFence - 指示はシステムメッセージのコードブロックの中に埋め込むため、指示に含まれる ``` でブロックが閉じないようにします
+func escapeCodeFence(text string) string {
+	return strings.ReplaceAll(text, "```", "` ` `")
 }
 
 func (c *config) LogLevel() logger.LogLevel {
@@ -77,10 +178,128 @@ func (c *config) SlackAppLevelToken() string {
 	return c.slackAppLevelToken
 }
 
+// SlackTransport - Slackからイベントを受け取る方法 (socket: Socket Mode, http: Events APIのリクエストURL)
+func (c *config) SlackTransport() string {
+	return c.slackTransport
+}
+
+// SlackSigningSecret - HTTPで受け取ったリクエストの署名を検証するためのSigning Secret
+func (c *config) SlackSigningSecret() string {
+	return c.slackSigningSecret
+}
+
 func (c *config) OpenAIModel() string {
 	return c.openAIModel
 }
 
+// OpenAIFallbackModels - OPENAI_MODELでの呼び出しに失敗した場合に順番に試すモデル
+func (c *config) OpenAIFallbackModels() []string {
+	return c.openAIFallbackModels
+}
+
+// AllowedModels - ユーザーが選択できるモデルの一覧
+func (c *config) AllowedModels() []string {
+	return c.allowedModels
+}
+
+// OpenAISummaryModel - 長いスレッドの古い会話を要約する際に利用するモデル
+func (c *config) OpenAISummaryModel() string {
+	return c.openAISummaryModel
+}
+
+// MaxPromptTokens - 一回のリクエストで送信する会話のトークン数の上限。超えた場合は古い会話を要約します
+func (c *config) MaxPromptTokens() int {
+	return c.maxPromptTokens
+}
+
+// CompletionReserveTokens - 回答の生成のためにコンテキストウィンドウに残しておくトークン数
+func (c *config) CompletionReserveTokens() int {
+	return c.completionReserve
+}
+
+// ModelContextWindows - モデルごとのコンテキストウィンドウの大きさ (組み込みの表を上書きします)
+func (c *config) ModelContextWindows() map[string]int {
+	return c.contextWindows
+}
+
+// ModelPrices - モデルごとの料金 (組み込みの料金表を上書きします)
+func (c *config) ModelPrices() map[string]ModelPrice {
+	return c.modelPrices
+}
+
+// Quotas - ユーザー・チャンネル・全体の利用上限
+func (c *config) Quotas() []Quota {
+	return c.quotas
+}
+
+// QuotaLocation - 利用上限の集計期間 (日・月) の区切りに利用するタイムゾーン
+func (c *config) QuotaLocation() *time.Location {
+	return c.quotaLocation
+}
+
+// AdminUserIDs - 利用上限の一時的な引き上げ等を行える管理者のSlackユーザーID
+func (c *config) AdminUserIDs() []string {
+	return c.adminUserIDs
+}
+
+// MaxConcurrentRequests - 同時に生成する回答の数の上限 (超えた分は順番待ちになります)
+func (c *config) MaxConcurrentRequests() int {
+	return c.maxConcurrent
+}
+
+// MaxConcurrentRequestsPerUser - ユーザーごとに同時に生成する回答の数の上限
+func (c *config) MaxConcurrentRequestsPerUser() int {
+	return c.maxConcurrentPerUser
+}
+
+// OpenAIMaxRetries - レート制限やサーバーエラー時に再試行する最大回数
+func (c *config) OpenAIMaxRetries() int {
+	return c.openAIMaxRetries
+}
+
+// OpenAIRetryMaxWait - 再試行で待機する時間の合計の上限
+func (c *config) OpenAIRetryMaxWait() time.Duration {
+	return c.openAIRetryMaxWait
+}
+
+// ToolsEnabled - モデルにツール (関数呼び出し) を提示するかどうか
+// ツール呼び出しに対応していないモデルを利用する場合は無効にします
+func (c *config) ToolsEnabled() bool {
+	return c.toolsEnabled
+}
+
+// MaxFileTokens - 添付されたテキストファイル一つあたりに含めるトークン数の上限
+func (c *config) MaxFileTokens() int {
+	return c.maxFileTokens
+}
+
+// MaxAttachmentTokens - 一つの会話に含める添付テキストファイルのトークン数の合計の上限
+func (c *config) MaxAttachmentTokens() int {
+	return c.maxAttachmentTokens
+}
+
+func (c *config) OpenAIAPIType() string {
+	return c.openAIAPIType
+}
+
+// OpenAIBaseURL - OpenAI互換のサーバー (Ollama, vLLM等) を利用する場合のベースURL
+func (c *config) OpenAIBaseURL() string {
+	return c.openAIBaseURL
+}
+
+func (c *config) AzureOpenAIEndpoint() string {
+	return c.azureEndpoint
+}
+
+func (c *config) AzureOpenAIAPIVersion() string {
+	return c.azureAPIVersion
+}
+
+// AzureOpenAIDeployments - モデル名からAzure OpenAIのデプロイメント名への対応表
+func (c *config) AzureOpenAIDeployments() map[string]string {
+	return c.azureDeployments
+}
+
 func (c *config) GoogleApplicationCredentialsJSON() string {
 	return os.Getenv("GOOGLE_APPLICATION_CREDENTIALS_JSON")
 }
@@ -93,39 +312,287 @@ func (c *config) SpreadSheetID() string {
 	return os.Getenv("SPREADSHEET_ID")
 }
 
+// DataDir - 利用量や個人設定を保存するディレクトリ。空の場合はメモリ上にのみ保持します
+func (c *config) DataDir() string {
+	return c.dataDir
+}
+
 func ProvideConfig() Config {
 	logLevel := os.Getenv("LOG_LEVEL")
+
+	// OpenAI互換のサーバーでは認証が不要な場合がある
+	openAIBaseURL := os.Getenv("OPENAI_BASE_URL")
 	openAIAPIKey := os.Getenv("OPENAI_API_KEY")
-	if openAIAPIKey == "" {
+	if openAIAPIKey == "" && openAIBaseURL == "" {
 		panic("OPENAI_API_KEY is required")
 	}
 
+	openAIAPIType := strings.ToLower(os.Getenv("OPENAI_API_TYPE"))
+	if openAIAPIType == "" {
+		openAIAPIType = OpenAIAPITypeOpenAI
+	}
+	if openAIAPIType != OpenAIAPITypeOpenAI && openAIAPIType != OpenAIAPITypeAzure {
+		panic("OPENAI_API_TYPE must be openai or azure")
+	}
+
 	openAIOrganizationID := os.Getenv("OPENAI_ORGANIZATION_ID")
-	if openAIOrganizationID == "" {
+	if openAIOrganizationID == "" && openAIAPIType == OpenAIAPITypeOpenAI && openAIBaseURL == "" {
 		panic("OPENAI_ORGANIZATION_ID is required")
 	}
 
+	azureEndpoint := os.Getenv("AZURE_OPENAI_ENDPOINT")
+	if azureEndpoint == "" && openAIAPIType == OpenAIAPITypeAzure {
+		panic("AZURE_OPENAI_ENDPOINT is required when OPENAI_API_TYPE is azure")
+	}
+
+	azureAPIVersion := os.Getenv("AZURE_OPENAI_API_VERSION")
+	if azureAPIVersion == "" {
+		azureAPIVersion = DefaultAzureOpenAIAPIVersion
+	}
+
 	slackBotToken := os.Getenv("SLACK_BOT_TOKEN")
 	if slackBotToken == "" {
 		panic("SLACK_BOT_TOKEN is required")
 	}
 
+	slackTransport := strings.ToLower(os.Getenv("SLACK_TRANSPORT"))
+	if slackTransport == "" {
+		slackTransport = SlackTransportSocket
+	}
+	if slackTransport != SlackTransportSocket && slackTransport != SlackTransportHTTP {
+		panic("SLACK_TRANSPORT must be socket or http")
+	}
+
 	slackAppLevelToken := os.Getenv("SLACK_APP_LEVEL_TOKEN")
-	if slackAppLevelToken == "" {
+	if slackAppLevelToken == "" && slackTransport == SlackTransportSocket {
 		panic("SLACK_APP_LEVEL_TOKEN is required")
 	}
 
+	slackSigningSecret := os.Getenv("SLACK_SIGNING_SECRET")
+	if slackSigningSecret == "" && slackTransport == SlackTransportHTTP {
+		panic("SLACK_SIGNING_SECRET is required when SLACK_TRANSPORT is http")
+	}
+
 	openAIModel := os.Getenv("OPENAI_MODEL")
 	if openAIModel == "" {
 		openAIModel = "gpt-4"
 	}
 
+	quotas, err := parseQuotas(os.Getenv("USAGE_QUOTAS"))
+	if err != nil {
+		panic("USAGE_QUOTAS is invalid: " + err.Error())
+	}
+
+	quotaLocation := time.Local
+	if name := os.Getenv("QUOTA_TIMEZONE"); name != "" {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			panic("QUOTA_TIMEZONE is invalid: " + err.Error())
+		}
+		quotaLocation = location
+	}
+
+	openAIFallbackModels := parseList(os.Getenv("OPENAI_FALLBACK_MODELS"))
+
+	// 指定がない場合は、設定されたモデルとフォールバック先のモデルを選択できる
+	allowedModels := parseList(os.Getenv("ALLOWED_MODELS"))
+	if len(allowedModels) == 0 {
+		seen := make(map[string]bool)
+		for _, model := range append([]string{openAIModel}, openAIFallbackModels...) {
+			if !seen[model] {
+				seen[model] = true
+				allowedModels = append(allowedModels, model)
+			}
+		}
+	}
+
+	// Azure OpenAIやOpenAI互換のサーバーには安価なモデルがあるとは限らないため、回答と同じモデルで要約する
+	openAISummaryModel := os.Getenv("OPENAI_SUMMARY_MODEL")
+	if openAISummaryModel == "" && openAIAPIType == OpenAIAPITypeOpenAI && openAIBaseURL == "" {
+		openAISummaryModel = DefaultOpenAISummaryModel
+	} else if openAISummaryModel == "" {
+		openAISummaryModel = openAIModel
+	}
+
 	return &config{
 		loglevel:             logger.LogLevel(logLevel),
 		openAIAPIKey:         openAIAPIKey,
 		openAIOrganizationID: openAIOrganizationID,
 		slackBotToken:        slackBotToken,
 		slackAppLevelToken:   slackAppLevelToken,
+		slackTransport:       slackTransport,
+		slackSigningSecret:   slackSigningSecret,
 		openAIModel:          openAIModel,
+		openAIFallbackModels: openAIFallbackModels,
+		allowedModels:        allowedModels,
+		openAISummaryModel:   openAISummaryModel,
+		maxPromptTokens:      parseInt(os.Getenv("MAX_PROMPT_TOKENS"), 16000),
+		completionReserve:    parseInt(os.Getenv("COMPLETION_RESERVE_TOKENS"), 4096),
+		contextWindows:       parseIntMap(os.Getenv("MODEL_CONTEXT_WINDOWS")),
+		modelPrices:          parsePriceMap(os.Getenv("MODEL_PRICES")),
+		quotas:               quotas,
+		quotaLocation:        quotaLocation,
+		adminUserIDs:         parseList(os.Getenv("ADMIN_USER_IDS")),
+		maxConcurrent:        parsePositiveInt(os.Getenv("MAX_CONCURRENT_REQUESTS"), 8),
+		maxConcurrentPerUser: parsePositiveInt(os.Getenv("MAX_CONCURRENT_REQUESTS_PER_USER"), 2),
+		openAIMaxRetries:     parseInt(os.Getenv("OPENAI_MAX_RETRIES"), 3),
+		openAIRetryMaxWait:   time.Duration(parseInt(os.Getenv("OPENAI_RETRY_MAX_WAIT_SECONDS"), 60)) * time.Second,
+		openAIAPIType:        openAIAPIType,
+		toolsEnabled:         parseBool(os.Getenv("ENABLE_TOOLS"), openAIBaseURL == ""),
+		maxFileTokens:        parseInt(os.Getenv("MAX_FILE_TOKENS"), 4000),
+		maxAttachmentTokens:  parseInt(os.Getenv("MAX_ATTACHMENT_TOKENS"), 12000),
+		openAIBaseURL:        openAIBaseURL,
+		azureEndpoint:        azureEndpoint,
+		azureAPIVersion:      azureAPIVersion,
+		azureDeployments:     parseKeyValueList(os.Getenv("AZURE_OPENAI_DEPLOYMENTS")),
+		dataDir:              strings.TrimSpace(os.Getenv("DATA_DIR")),
+	}
+}
+
+// parseInt - 数値の文字列を変換します。空文字や不正な値の場合はデフォルト値を返します
+func parseInt(input string, defaultValue int) int {
+	value, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// parsePositiveInt - 正の数値の文字列を変換します。空文字や不正な値、0以下の場合はデフォルト値を返します
+func parsePositiveInt(input string, defaultValue int) int {
+	value := parseInt(input, defaultValue)
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// parseBool - 真偽値の文字列を変換します。空文字や不正な値の場合はデフォルト値を返します
+func parseBool(input string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(strings.TrimSpace(input))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// parseList - カンマ区切りの文字列をスライスに変換します
+func parseList(input string) []string {
+	var result []string
+	for _, item := range strings.Split(input, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseKeyValueList - "key1=value1,key2=value2" 形式の文字列をmapに変換します
+func parseKeyValueList(input string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(input, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		if key == "" || value == "" {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// parseIntMap - "key1=1,key2=2" 形式の文字列をmapに変換します。数値でない値は無視します
+func parseIntMap(input string) map[string]int {
+	result := make(map[string]int)
+	for key, value := range parseKeyValueList(input) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			continue
+		}
+		result[key] = n
 	}
+	return result
+}
+
+// parsePriceMap - "model1=input:output,model2=input:output" 形式の文字列をmapに変換します。不正な値は無視します
+func parsePriceMap(input string) map[string]ModelPrice {
+	result := make(map[string]ModelPrice)
+	for key, value := range parseKeyValueList(input) {
+		prices := strings.SplitN(value, ":", 2)
+		if len(prices) != 2 {
+			continue
+		}
+
+		in, err := strconv.ParseFloat(strings.TrimSpace(prices[0]), 64)
+		if err != nil {
+			continue
+		}
+		out, err := strconv.ParseFloat(strings.TrimSpace(prices[1]), 64)
+		if err != nil {
+			continue
+		}
+		result[key] = ModelPrice{Input: in, Output: out}
+	}
+	return result
+}
+
+// parseQuotas - "scope.period.metric=limit,..." 形式の文字列を利用上限に変換します
+// 設定の誤りで上限が効かなくなるのを防ぐため、不正な値はエラーにします。結果は設定の順序によらず同じ並びになります
+func parseQuotas(input string) ([]Quota, error) {
+	var quotas []Quota
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(input, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q must be scope.period.metric=limit", strings.TrimSpace(pair))
+		}
+
+		key := strings.TrimSpace(kv[0])
+		fields := strings.Split(key, ".")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%q must be scope.period.metric", key)
+		}
+
+		quota := Quota{Scope: fields[0], Period: fields[1], Metric: fields[2]}
+		switch {
+		case quota.Scope != QuotaScopeUser && quota.Scope != QuotaScopeChannel && quota.Scope != QuotaScopeGlobal:
+			return nil, fmt.Errorf("%q has unknown scope %q", key, quota.Scope)
+		case quota.Period != QuotaPeriodDaily && quota.Period != QuotaPeriodMonthly:
+			return nil, fmt.Errorf("%q has unknown period %q", key, quota.Period)
+		case quota.Metric != QuotaMetricTokens && quota.Metric != QuotaMetricCost:
+			return nil, fmt.Errorf("%q has unknown metric %q", key, quota.Metric)
+		}
+
+		value := strings.TrimSpace(kv[1])
+		limit, err := strconv.ParseFloat(value, 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%q has invalid limit %q", key, value)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%q is specified more than once", key)
+		}
+		seen[key] = true
+
+		quota.Limit = limit
+		quotas = append(quotas, quota)
+	}
+
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].key() < quotas[j].key()
+	})
+	return quotas, nil
+}
+
+func (q Quota) key() string {
+	return q.Scope + "." + q.Period + "." + q.Metric
 }
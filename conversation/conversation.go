@@ -1,9 +1,22 @@
 package conversation
 
 import (
-	"github.com/pkoukk/tiktoken-go"
+	"fmt"
 	"github.com/sashabaranov/go-openai"
 	"github.com/slack-go/slack"
+	"strings"
+)
+
+const (
+	// ImageOmittedNote - 画像に対応していないモデルに送信する際、画像の代わりに挿入する注記
+	ImageOmittedNote = "(画像「%s」が添付されていますが、現在のモデルは画像を扱えないため省略されています)"
+
+	// PromptMetadataEventType - スラッシュコマンド等でボットが代わりに投稿した質問に付けるメタデータの種類
+	// このメタデータを持つボットのメッセージは、質問したユーザーのメッセージとして扱います
+	PromptMetadataEventType = "sge_bot_prompt"
+
+	// SummaryHeader - 要約した過去の会話をモデルに渡す際の見出し
+	SummaryHeader = "以下はこのスレッドのこれまでの会話の要約です。古いメッセージはこの要約に置き換えられています。\n\n"
 )
 
 type (
@@ -18,31 +31,49 @@ type (
 		// Tokens - 現在の会話のトークン数を取得します
 		Tokens(model string) int
 
-		// ToChatCompletionMessage - 会話をChatCompletionMessageに変換します
-		ToChatCompletionMessage() []openai.ChatCompletionMessage
+		// MessageTokens - メッセージごとのトークン数をMessagesと同じ順番で取得します
+		// システムプロンプトや要約の分は含まれないため、合計はTokensより小さくなります
+		MessageTokens(model string) []int
+
+		// ToChatCompletionMessage - 会話を指定したモデル向けのChatCompletionMessageに変換します
+		ToChatCompletionMessage(model string) []openai.ChatCompletionMessage
 
 		// RemoveMessageAfterTimestamp - 指定したタイムスタンプ以降のメッセージを削除します
 		RemoveMessageAfterTimestamp(timestamp string)
 
-		// TrimMessagesToSaveToken - トークン数を指定した数になるまでメッセージを削除します
-		TrimMessagesToSaveToken(model string, maxTokens int)
+		// AddMessage - 会話の末尾にメッセージを追加します
+		AddMessage(message Message)
+
+		// Clone - 会話を複製します。メッセージの追加・削除は元の会話に影響しません
+		Clone() Conversation
+
+		// SetSummary - 過去の会話の要約を設定します。要約はシステムメッセージの直後に送信されます
+		SetSummary(summary string)
+
+		// RemoveOldestMessages - 古い順に指定した数のメッセージを会話から取り除き、取り除いたメッセージを返します
+		RemoveOldestMessages(count int) []Message
+
+		// RemoveMessagesToFit - トークン数が指定した数に収まるまで古いメッセージを取り除き、取り除いたメッセージを返します
+		// ツールの呼び出しとその実行結果はまとめて取り除きます。最後のメッセージ (とその実行結果) は残します
+		RemoveMessagesToFit(model string, maxTokens int) []Message
 	}
 
 	conv struct {
 		system   string
+		summary  string
 		messages []Message
 	}
-)
 
-func (c *conv) TrimMessagesToSaveToken(model string, maxTokens int) {
-	for i := 0; i < len(c.messages); i++ {
-		if c.Tokens(model) > maxTokens {
-			c.messages[i].SetContent("deleted to save token")
-		} else {
-			break
-		}
+	// builtMessage - モデルのプロファイルを適用した送信用のメッセージ
+	builtMessage struct {
+		role       string
+		text       string
+		name       string
+		images     []Part
+		toolCalls  []ToolCall
+		toolCallID string
 	}
-}
+)
 
 func (c *conv) RemoveMessageByTimestamp(timestamp string) (Message, bool) {
 	for i, m := range c.messages {
@@ -67,7 +98,36 @@ func (c *conv) RemoveMessageAfterTimestamp(timestamp string) {
 }
 
 func (c *conv) Tokens(model string) int {
-	tkm, err := tiktoken.EncodingForModel(model)
+	profile := ProfileForModel(model)
+	header, system := c.buildHeader(profile)
+
+	var numTokens int
+	for _, message := range header {
+		numTokens += builtMessageTokens(model, message)
+	}
+	if system != "" {
+		// 最初のユーザーメッセージに結合される指示の分 (メッセージとしてのオーバーヘッドは含めない)
+		numTokens += CountTokens(model, system+"\n\n---\n\n")
+	}
+	for _, n := range c.MessageTokens(model) {
+		numTokens += n
+	}
+
+	numTokens += 3
+	return numTokens
+}
+
+func (c *conv) MessageTokens(model string) []int {
+	counts := make([]int, len(c.messages))
+	for i, m := range c.messages {
+		counts[i] = m.Tokens(model)
+	}
+	return counts
+}
+
+// builtMessageTokens - 送信するメッセージ一つあたりのトークン数を数えます
+func builtMessageTokens(model string, message builtMessage) int {
+	tkm, err := encodingForModel(model)
 	if err != nil {
 		return 0
 	}
@@ -76,25 +136,73 @@ func (c *conv) Tokens(model string) int {
 	tokensPerMessage := 3
 	tokensPerName := 1
 
-	var numTokens int
-	if c.system != "" {
-		numTokens += tokensPerMessage
-		numTokens += len(tkm.Encode(c.system, nil, nil))
-		numTokens += len(tkm.Encode(openai.ChatMessageRoleSystem, nil, nil))
-	}
-
-	for _, message := range c.messages {
-		numTokens += tokensPerMessage
-		numTokens += len(tkm.Encode(message.Content(), nil, nil))
-		numTokens += len(tkm.Encode(message.Role(), nil, nil))
-		numTokens += len(tkm.Encode(message.UserName(), nil, nil))
-		if message.UserName() != "" {
-			numTokens += tokensPerName
+	numTokens := tokensPerMessage
+	numTokens += len(tkm.Encode(message.text, nil, nil))
+	for _, image := range message.images {
+		numTokens += imageTokens(image)
+	}
+	numTokens += len(tkm.Encode(message.role, nil, nil))
+	numTokens += len(tkm.Encode(message.name, nil, nil))
+	if message.name != "" {
+		numTokens += tokensPerName
+	}
+	for _, call := range message.toolCalls {
+		numTokens += len(tkm.Encode(call.Name, nil, nil))
+		numTokens += len(tkm.Encode(call.Arguments, nil, nil))
+	}
+	return numTokens
+}
+
+func (c *conv) AddMessage(message Message) {
+	c.messages = append(c.messages, message)
+}
+
+func (c *conv) Clone() Conversation {
+	messages := make([]Message, len(c.messages))
+	copy(messages, c.messages)
+	return &conv{
+		system:   c.system,
+		summary:  c.summary,
+		messages: messages,
+	}
+}
+
+func (c *conv) SetSummary(summary string) {
+	c.summary = summary
+}
+
+func (c *conv) RemoveOldestMessages(count int) []Message {
+	if count > len(c.messages) {
+		count = len(c.messages)
+	}
+
+	removed := make([]Message, count)
+	copy(removed, c.messages[:count])
+	c.messages = c.messages[count:]
+	return removed
+}
+
+func (c *conv) RemoveMessagesToFit(model string, maxTokens int) []Message {
+	total := c.Tokens(model)
+	counts := c.MessageTokens(model)
+
+	removed := 0
+	for total > maxTokens {
+		// ツールの呼び出しと実行結果は、片方だけでは送信できないため一緒に取り除く
+		end := removed + 1
+		for end < len(c.messages) && c.messages[end].Role() == openai.ChatMessageRoleTool {
+			end++
+		}
+		if end >= len(c.messages) {
+			break
+		}
+
+		for ; removed < end; removed++ {
+			total -= counts[removed]
 		}
 	}
 
-	numTokens += 3
-	return numTokens
+	return c.RemoveOldestMessages(removed)
 }
 
 func (c *conv) Messages() []Message {
@@ -105,29 +213,138 @@ func (c *conv) SystemMessage(content string) {
 	c.system = content
 }
 
-func (c *conv) ToChatCompletionMessage() []openai.ChatCompletionMessage {
+func (c *conv) ToChatCompletionMessage(model string) []openai.ChatCompletionMessage {
 	var messages []openai.ChatCompletionMessage
+	for _, m := range c.build(ProfileForModel(model)) {
+		// NEVER OUTPUT LOGS TO PROTECT PRIVACY
+		// fmt.Printf("MESSAGE: %s\n", m.text)
 
-	if c.system != "" {
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    openai.ChatMessageRoleAssistant,
-			Content: c.system,
-		})
+		message := openai.ChatCompletionMessage{
+			Role:       m.role,
+			Name:       m.name,
+			ToolCallID: m.toolCallID,
+		}
+		if len(m.images) == 0 {
+			message.Content = m.text
+		} else {
+			message.MultiContent = append(message.MultiContent, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeText,
+				Text: m.text,
+			})
+			for _, image := range m.images {
+				message.MultiContent = append(message.MultiContent, openai.ChatMessagePart{
+					Type: openai.ChatMessagePartTypeImageURL,
+					ImageURL: &openai.ChatMessageImageURL{
+						URL:    image.ImageURL,
+						Detail: openai.ImageURLDetailAuto,
+					},
+				})
+			}
+		}
+		for _, call := range m.toolCalls {
+			message.ToolCalls = append(message.ToolCalls, openai.ToolCall{
+				ID:   call.ID,
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      call.Name,
+					Arguments: call.Arguments,
+				},
+			})
+		}
+
+		messages = append(messages, message)
 	}
+	return messages
+}
+
+// build - プロファイルに従って送信するメッセージの一覧を作成します
+// ToChatCompletionMessageとTokensで同じ内容を扱うために利用します
+func (c *conv) build(profile Profile) []builtMessage {
+	messages, system := c.buildHeader(profile)
 
 	for _, m := range c.messages {
-		// NEVER OUTPUT LOGS TO PROTECT PRIVACY
-		// fmt.Printf("MESSAGE: %s\n", m.Content())
+		message := buildMessage(m, profile)
 
-		messages = append(messages, openai.ChatCompletionMessage{
-			Role:    m.Role(),
-			Content: m.Content(),
-			Name:    m.UserName(),
-		})
+		// システムプロンプトを受け付けないモデルでは、最初のユーザーメッセージの前に指示として含める
+		if system != "" && message.role == openai.ChatMessageRoleUser {
+			message.text = system + "\n\n---\n\n" + message.text
+			system = ""
+		}
+
+		messages = append(messages, message)
 	}
+
+	if system != "" {
+		messages = append([]builtMessage{{role: openai.ChatMessageRoleUser, text: system}}, messages...)
+	}
+
 	return messages
 }
 
+// buildHeader - 会話の先頭に送信するシステムプロンプトと要約のメッセージを作成します
+// システムプロンプトを受け付けないモデルの場合は、最初のユーザーメッセージに結合する指示を併せて返します
+func (c *conv) buildHeader(profile Profile) ([]builtMessage, string) {
+	var messages []builtMessage
+
+	system := c.system
+	if system != "" && !profile.MergeSystemIntoFirstUser {
+		messages = append(messages, builtMessage{
+			role: profile.SystemRole,
+			text: system,
+		})
+		system = ""
+	}
+
+	if c.summary != "" {
+		if profile.MergeSystemIntoFirstUser {
+			system = strings.TrimSpace(system + "\n\n" + SummaryHeader + c.summary)
+		} else {
+			messages = append(messages, builtMessage{
+				role: profile.SystemRole,
+				text: SummaryHeader + c.summary,
+			})
+		}
+	}
+
+	return messages, system
+}
+
+// buildMessage - 会話のメッセージを送信する形式に変換します
+func buildMessage(m Message, profile Profile) builtMessage {
+	text, images := messageContent(m, profile.SupportsVision)
+	message := builtMessage{
+		role:       m.Role(),
+		text:       text,
+		images:     images,
+		toolCalls:  m.ToolCalls(),
+		toolCallID: m.ToolCallID(),
+	}
+	if profile.SupportsName {
+		message.name = m.UserName()
+	}
+	return message
+}
+
+// messageContent - メッセージの本文と添付された画像を取得します
+// 画像に対応していないモデルの場合、画像は省略した旨の注記に置き換えます
+func messageContent(m Message, vision bool) (string, []Part) {
+	text := m.Content()
+	var images []Part
+	for _, part := range m.Parts() {
+		switch part.Type {
+		case PartTypeText:
+			text += "\n\n" + part.Text
+		case PartTypeImage:
+			if vision {
+				images = append(images, part)
+			} else {
+				text += "\n\n" + fmt.Sprintf(ImageOmittedNote, part.Name)
+			}
+		}
+	}
+	return text, images
+}
+
 func NewConversation(messages []Message) Conversation {
 	return &conv{
 		messages: messages,
@@ -137,6 +354,14 @@ func NewConversation(messages []Message) Conversation {
 func NewConversationFromSlackMessages(message []slack.Message, botUserID string) Conversation {
 	var messages []Message
 	for _, m := range message {
+		if m.User == botUserID && m.Metadata.EventType == PromptMetadataEventType {
+			userID, _ := m.Metadata.EventPayload["user_id"].(string)
+			prompt, _ := m.Metadata.EventPayload["prompt"].(string)
+			text := prompt + " (UserID: <@" + userID + ">)"
+			messages = append(messages, NewMessage(openai.ChatMessageRoleUser, text, "", m.Timestamp))
+			continue
+		}
+
 		if m.User == botUserID {
 			if len(m.Blocks.BlockSet) >= 1 {
 				block, ok := m.Blocks.BlockSet[0].(*slack.ActionBlock)
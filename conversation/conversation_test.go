@@ -0,0 +1,110 @@
+package conversation
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"os"
+	"strings"
+	"testing"
+)
+
+// byteBpeLoader - 1バイトを1トークンとして扱うエンコーディング (テストではエンコーディングをダウンロードしない)
+type byteBpeLoader struct{}
+
+func (byteBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	return ranks, nil
+}
+
+func TestMain(m *testing.M) {
+	tiktoken.SetBpeLoader(byteBpeLoader{})
+	os.Exit(m.Run())
+}
+
+func TestRemoveMessagesToFit(t *testing.T) {
+	long := strings.Repeat("a", 1000)
+	user := func() Message { return NewMessage(openai.ChatMessageRoleUser, long, "", "") }
+	assistant := func() Message { return NewMessage(openai.ChatMessageRoleAssistant, long, "", "") }
+	toolCall := func() Message {
+		return NewToolCallMessage("", []ToolCall{{ID: "call_1", Name: "calculate", Arguments: `{"expression":"1+1"}`}})
+	}
+	toolResult := func() Message { return NewToolResultMessage("call_1", long) }
+
+	tests := []struct {
+		name        string
+		messages    []Message
+		maxTokens   int
+		wantRemoved int
+		wantRoles   []string
+	}{
+		{
+			name:        "fits",
+			messages:    []Message{user(), assistant(), user()},
+			maxTokens:   10000,
+			wantRemoved: 0,
+			wantRoles:   []string{"user", "assistant", "user"},
+		},
+		{
+			name:        "remove oldest",
+			messages:    []Message{user(), assistant(), user(), assistant()},
+			maxTokens:   2500,
+			wantRemoved: 2,
+			wantRoles:   []string{"user", "assistant"},
+		},
+		{
+			name:        "keep last message",
+			messages:    []Message{user(), assistant(), user()},
+			maxTokens:   10,
+			wantRemoved: 2,
+			wantRoles:   []string{"user"},
+		},
+		{
+			name:        "remove tool call with its results",
+			messages:    []Message{user(), toolCall(), toolResult(), toolResult(), user()},
+			maxTokens:   1500,
+			wantRemoved: 4,
+			wantRoles:   []string{"user"},
+		},
+		{
+			name:        "keep pending tool call with its result",
+			messages:    []Message{user(), toolCall(), toolResult()},
+			maxTokens:   10,
+			wantRemoved: 1,
+			wantRoles:   []string{"assistant", "tool"},
+		},
+		{
+			name:        "remove orphan tool results",
+			messages:    []Message{toolResult(), user(), assistant(), user()},
+			maxTokens:   2500,
+			wantRemoved: 2,
+			wantRoles:   []string{"assistant", "user"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conv := NewConversation(tt.messages)
+			removed := conv.RemoveMessagesToFit("gpt-4o", tt.maxTokens)
+
+			if len(removed) != tt.wantRemoved {
+				t.Errorf("removed %d messages, want %d", len(removed), tt.wantRemoved)
+			}
+
+			var roles []string
+			for _, m := range conv.Messages() {
+				roles = append(roles, m.Role())
+			}
+			if strings.Join(roles, ",") != strings.Join(tt.wantRoles, ",") {
+				t.Errorf("remaining roles = %v, want %v", roles, tt.wantRoles)
+			}
+
+			// 最後のメッセージを残すために収まらない場合を除き、上限に収まっていること
+			if tt.maxTokens >= 1000 && conv.Tokens("gpt-4o") > tt.maxTokens {
+				t.Errorf("tokens = %d, want <= %d", conv.Tokens("gpt-4o"), tt.maxTokens)
+			}
+		})
+	}
+}
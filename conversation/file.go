@@ -0,0 +1,31 @@
+package conversation
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// TruncatedFileNote - ファイルの内容を切り詰めた場合に末尾に追加する注記
+	TruncatedFileNote = "(トークン数の上限のため、以降の内容は省略されています)"
+)
+
+// NewTextFilePart - テキストファイルの内容をファイル名付きのコードブロックとしてPartを作成します
+func NewTextFilePart(name string, content string, truncated bool) Part {
+	// 内容にコードブロックが含まれていても崩れないよう、より長いフェンスで囲む
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+
+	text := fmt.Sprintf("添付ファイル: %s\n%s\n%s\n%s", name, fence, strings.TrimRight(content, "\n"), fence)
+	if truncated {
+		text += "\n" + TruncatedFileNote
+	}
+
+	return Part{
+		Type: PartTypeText,
+		Name: name,
+		Text: text,
+	}
+}
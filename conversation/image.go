@@ -0,0 +1,121 @@
+package conversation
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+)
+
+const (
+	// MaxImageLongSide, MaxImageShortSide - 送信する画像の最大サイズ
+	// OpenAIのAPIでもこのサイズまで縮小されるため、これ以上大きな画像を送っても意味がない
+	MaxImageLongSide  = 2048
+	MaxImageShortSide = 768
+
+	// MaxImageBytes - 送信する画像一枚あたりのサイズの上限
+	MaxImageBytes = 1024 * 1024
+
+	// maxImagePixels - デコードする画像の画素数の上限 (巨大な画像によるメモリ不足を防ぐ)
+	maxImagePixels = 50_000_000
+
+	imageJPEGQuality = 85
+)
+
+// NewImagePart - 画像データを縮小・JPEGに変換してPartを作成します
+func NewImagePart(name string, data []byte) (Part, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to decode image config %s: %v", name, err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return Part{}, fmt.Errorf("image %s is too large: %dx%d", name, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Part{}, fmt.Errorf("failed to decode image %s: %v", name, err)
+	}
+
+	width, height := fitImageSize(img.Bounds().Dx(), img.Bounds().Dy())
+	for {
+		encoded, err := encodeJPEG(img, width, height)
+		if err != nil {
+			return Part{}, fmt.Errorf("failed to encode image %s: %v", name, err)
+		}
+
+		if len(encoded) <= MaxImageBytes || width <= 64 || height <= 64 {
+			return Part{
+				Type:     PartTypeImage,
+				Name:     name,
+				ImageURL: "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(encoded),
+				Width:    width,
+				Height:   height,
+			}, nil
+		}
+
+		width, height = width*3/4, height*3/4
+	}
+}
+
+// NewImageOmittedPart - 画像に対応していないモデル向けに、画像をダウンロードせず省略した旨の注記だけのPartを作成します
+func NewImageOmittedPart(name string) Part {
+	return Part{
+		Type: PartTypeText,
+		Name: name,
+		Text: fmt.Sprintf(ImageOmittedNote, name),
+	}
+}
+
+// fitImageSize - 縦横比を保ったまま画像の最大サイズに収まるサイズを計算します
+func fitImageSize(width int, height int) (int, int) {
+	scale := 1.0
+	long, short := width, height
+	if short > long {
+		long, short = short, long
+	}
+
+	if long > MaxImageLongSide {
+		scale = float64(MaxImageLongSide) / float64(long)
+	}
+	if float64(short)*scale > MaxImageShortSide {
+		scale = float64(MaxImageShortSide) / float64(short)
+	}
+
+	scaledWidth, scaledHeight := int(float64(width)*scale), int(float64(height)*scale)
+	if scaledWidth < 1 {
+		scaledWidth = 1
+	}
+	if scaledHeight < 1 {
+		scaledHeight = 1
+	}
+	return scaledWidth, scaledHeight
+}
+
+func encodeJPEG(src image.Image, width int, height int) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// 透過部分は白で塗りつぶす (JPEGは透過を扱えない)
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: imageJPEGQuality})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageTokens - 画像一枚あたりのトークン数を見積もります (high detailの場合の計算式)
+func imageTokens(part Part) int {
+	tilesX := (part.Width + 511) / 512
+	tilesY := (part.Height + 511) / 512
+	return 85 + 170*tilesX*tilesY
+}
@@ -1,5 +1,9 @@
 package conversation
 
+import (
+	"github.com/sashabaranov/go-openai"
+)
+
 type (
 	// Message - 会話に含まれる一つのメッセージ
 	Message interface {
@@ -8,18 +12,48 @@ type (
 		SetContent(content string)
 		UserName() string
 		TimeStamp() string
+
+		// ToolCalls - アシスタントが要求したツールの呼び出し
+		ToolCalls() []ToolCall
+
+		// ToolCallID - ツールの実行結果の場合、対応するツール呼び出しのID
+		ToolCallID() string
+
+		// Parts - 本文とは別に添付された内容 (画像等)
+		Parts() []Part
+
+		// AddPart - 添付された内容を追加します
+		AddPart(part Part)
+
+		// Tokens - 指定したモデルに送信する場合のメッセージのトークン数を取得します
+		// 計算結果はモデルごとに保持し、内容が変更されると破棄します
+		Tokens(model string) int
+	}
+
+	// ToolCall - アシスタントによるツールの呼び出し
+	ToolCall struct {
+		ID        string
+		Name      string
+		Arguments string
 	}
 
 	message struct {
-		role      string
-		content   string
-		username  string
-		timeStamp string
+		role       string
+		content    string
+		username   string
+		timeStamp  string
+		toolCalls  []ToolCall
+		toolCallID string
+		parts      []Part
+
+		// tokens - モデルごとのトークン数のキャッシュ
+		tokens map[string]int
 	}
 )
 
 func (m *message) SetContent(content string) {
 	m.content = content
+	m.tokens = nil
 }
 
 func (m message) Role() string {
@@ -38,6 +72,37 @@ func (m message) TimeStamp() string {
 	return m.timeStamp
 }
 
+func (m message) ToolCalls() []ToolCall {
+	return m.toolCalls
+}
+
+func (m message) ToolCallID() string {
+	return m.toolCallID
+}
+
+func (m message) Parts() []Part {
+	return m.parts
+}
+
+func (m *message) AddPart(part Part) {
+	m.parts = append(m.parts, part)
+	m.tokens = nil
+}
+
+func (m *message) Tokens(model string) int {
+	if n, ok := m.tokens[model]; ok {
+		return n
+	}
+
+	profile := ProfileForModel(model)
+	n := builtMessageTokens(model, buildMessage(m, profile))
+	if m.tokens == nil {
+		m.tokens = make(map[string]int)
+	}
+	m.tokens[model] = n
+	return n
+}
+
 func NewMessage(role string, content string, username string, timeStamp string) Message {
 	return &message{
 		role:      role,
@@ -46,3 +111,21 @@ func NewMessage(role string, content string, username string, timeStamp string)
 		timeStamp: timeStamp,
 	}
 }
+
+// NewToolCallMessage - ツールの呼び出しを要求するアシスタントのメッセージを作成します
+func NewToolCallMessage(content string, toolCalls []ToolCall) Message {
+	return &message{
+		role:      openai.ChatMessageRoleAssistant,
+		content:   content,
+		toolCalls: toolCalls,
+	}
+}
+
+// NewToolResultMessage - ツールの実行結果のメッセージを作成します
+func NewToolResultMessage(toolCallID string, content string) Message {
+	return &message{
+		role:       openai.ChatMessageRoleTool,
+		content:    content,
+		toolCallID: toolCallID,
+	}
+}
@@ -0,0 +1,28 @@
+package conversation
+
+const (
+	PartTypeText  PartType = "text"
+	PartTypeImage PartType = "image"
+)
+
+type (
+	PartType string
+
+	// Part - メッセージ本文とは別に添付される内容 (画像等)
+	Part struct {
+		Type PartType
+
+		// Name - 添付ファイルの名前
+		Name string
+
+		// Text - テキストの場合の内容
+		Text string
+
+		// ImageURL - 画像の場合のdata URL (data:image/jpeg;base64,...)
+		ImageURL string
+
+		// Width, Height - 画像の場合のサイズ (トークン数の見積もりに利用します)
+		Width  int
+		Height int
+	}
+)
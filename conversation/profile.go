@@ -0,0 +1,97 @@
+package conversation
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+type (
+	// Profile - モデルごとのメッセージの送り方の違い
+	Profile struct {
+		// SystemRole - システムプロンプトを送信する際のロール (system または developer)
+		SystemRole string
+
+		// MergeSystemIntoFirstUser - システムプロンプトを受け付けないモデルの場合、最初のユーザーメッセージに結合して送信します
+		MergeSystemIntoFirstUser bool
+
+		// SupportsName - メッセージのnameフィールドを受け付けるかどうか
+		SupportsName bool
+
+		// SupportsVision - 画像の入力に対応しているかどうか
+		SupportsVision bool
+
+		// SupportsTools - ツールの呼び出し (tools) に対応しているかどうか
+		SupportsTools bool
+	}
+
+	profileEntry struct {
+		prefix  string
+		profile Profile
+	}
+)
+
+var (
+	chatProfile = Profile{
+		SystemRole:    openai.ChatMessageRoleSystem,
+		SupportsName:  true,
+		SupportsTools: true,
+	}
+
+	visionChatProfile = Profile{
+		SystemRole:     openai.ChatMessageRoleSystem,
+		SupportsName:   true,
+		SupportsVision: true,
+		SupportsTools:  true,
+	}
+
+	reasoningProfile = Profile{
+		SystemRole:     openai.ChatMessageRoleDeveloper,
+		SupportsName:   true,
+		SupportsVision: true,
+		SupportsTools:  true,
+	}
+
+	// defaultProfile - 表に含まれないモデル (OpenAI互換のサーバー上のモデル等) のプロファイル
+	// nameフィールドやツールの呼び出しを受け付けないサーバーがあるため送信しない
+	defaultProfile = Profile{
+		SystemRole: openai.ChatMessageRoleSystem,
+	}
+
+	// profiles - モデル名の前方一致でプロファイルを決定します。上にあるものが優先されます
+	profiles = []profileEntry{
+		{prefix: "o1-mini", profile: Profile{MergeSystemIntoFirstUser: true}},
+		{prefix: "o1-preview", profile: Profile{MergeSystemIntoFirstUser: true}},
+		{prefix: "o3-mini", profile: Profile{SystemRole: openai.ChatMessageRoleDeveloper, SupportsName: true, SupportsTools: true}},
+		{prefix: "o1", profile: reasoningProfile},
+		{prefix: "o3", profile: reasoningProfile},
+		{prefix: "o4", profile: reasoningProfile},
+		{prefix: "gpt-5", profile: reasoningProfile},
+		{prefix: "gpt-4o", profile: visionChatProfile},
+		{prefix: "chatgpt-4o", profile: visionChatProfile},
+		{prefix: "gpt-4.1", profile: visionChatProfile},
+		{prefix: "gpt-4.5", profile: visionChatProfile},
+		{prefix: "gpt-4-turbo", profile: visionChatProfile},
+		{prefix: "gpt-4-vision", profile: visionChatProfile},
+		{prefix: "gpt-", profile: chatProfile},
+	}
+)
+
+// ProfileForModel - モデルのプロファイルを取得します
+func ProfileForModel(model string) Profile {
+	for _, entry := range profiles {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.profile
+		}
+	}
+	return defaultProfile
+}
+
+// SupportsVision - モデルが画像の入力に対応しているかどうかを判定します
+func SupportsVision(model string) bool {
+	return ProfileForModel(model).SupportsVision
+}
+
+// SupportsTools - モデルがツールの呼び出しに対応しているかどうかを判定します
+func SupportsTools(model string) bool {
+	return ProfileForModel(model).SupportsTools
+}
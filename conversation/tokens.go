@@ -0,0 +1,63 @@
+package conversation
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	"sync"
+)
+
+var (
+	encodingsMu sync.Mutex
+
+	// encodings - モデルごとのエンコーディングのキャッシュ (作成に時間がかかるため使い回す)
+	encodings = make(map[string]*tiktoken.Tiktoken)
+)
+
+// CountTokens - テキストのトークン数を数えます
+func CountTokens(model string, text string) int {
+	tkm, err := encodingForModel(model)
+	if err != nil {
+		return 0
+	}
+
+	return len(tkm.Encode(text, nil, nil))
+}
+
+// TruncateTokens - テキストを指定したトークン数以下に切り詰めます。切り詰めた場合はtrueを返します
+func TruncateTokens(model string, text string, maxTokens int) (string, bool) {
+	tkm, err := encodingForModel(model)
+	if err != nil {
+		return text, false
+	}
+
+	tokens := tkm.Encode(text, nil, nil)
+	if len(tokens) <= maxTokens {
+		return text, false
+	}
+	if maxTokens <= 0 {
+		return "", true
+	}
+
+	return tkm.Decode(tokens[:maxTokens]), true
+}
+
+// encodingForModel - モデルに対応するエンコーディングを取得します
+// OpenAI互換のサーバーで動作するモデル等、tiktokenが知らないモデルの場合は近似値としてcl100k_baseを利用します
+func encodingForModel(model string) (*tiktoken.Tiktoken, error) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if tkm, ok := encodings[model]; ok {
+		return tkm, nil
+	}
+
+	tkm, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		tkm, err = tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	encodings[model] = tkm
+	return tkm, nil
+}
@@ -0,0 +1,50 @@
+package conversation
+
+import "strings"
+
+const (
+	// DefaultContextWindow - 表に含まれないモデルのコンテキストウィンドウの大きさ
+	DefaultContextWindow = 8192
+)
+
+type windowEntry struct {
+	prefix string
+	tokens int
+}
+
+// contextWindows - モデル名の前方一致でコンテキストウィンドウの大きさを決定します。上にあるものが優先されます
+var contextWindows = []windowEntry{
+	{prefix: "gpt-5", tokens: 400_000},
+	{prefix: "gpt-4.1", tokens: 1_047_576},
+	{prefix: "gpt-4.5", tokens: 128_000},
+	{prefix: "gpt-4o", tokens: 128_000},
+	{prefix: "chatgpt-4o", tokens: 128_000},
+	{prefix: "gpt-4-turbo", tokens: 128_000},
+	{prefix: "gpt-4-vision", tokens: 128_000},
+	{prefix: "gpt-4-1106", tokens: 128_000},
+	{prefix: "gpt-4-0125", tokens: 128_000},
+	{prefix: "gpt-4-32k", tokens: 32_768},
+	{prefix: "gpt-4", tokens: 8_192},
+	{prefix: "gpt-3.5-turbo-instruct", tokens: 4_096},
+	{prefix: "gpt-3.5-turbo", tokens: 16_385},
+	{prefix: "o1-mini", tokens: 128_000},
+	{prefix: "o1-preview", tokens: 128_000},
+	{prefix: "o1", tokens: 200_000},
+	{prefix: "o3", tokens: 200_000},
+	{prefix: "o4", tokens: 200_000},
+}
+
+// ContextWindow - モデルのコンテキストウィンドウの大きさを取得します
+// overridesにモデル名が含まれている場合は、組み込みの表よりも優先します
+func ContextWindow(model string, overrides map[string]int) int {
+	if tokens, ok := overrides[model]; ok {
+		return tokens
+	}
+
+	for _, entry := range contextWindows {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.tokens
+		}
+	}
+	return DefaultContextWindow
+}
@@ -2,62 +2,336 @@ package gpt
 
 import (
 	"context"
+	"fmt"
 	"github.com/SGE-AI/sge-bot/config"
 	"github.com/SGE-AI/sge-bot/conversation"
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/sashabaranov/go-openai"
+	"math"
+	"regexp"
+	"strings"
+	"time"
 )
 
+const (
+	// azureStreamUsageAPIVersion - Azure OpenAIでstream_optionsが利用可能になったAPIバージョン
+	azureStreamUsageAPIVersion = "2024-09-01-preview"
+)
+
+// azureDeploymentInvalidChars - Azure OpenAIのデプロイメント名に使えない文字
+var azureDeploymentInvalidChars = regexp.MustCompile(`[.:]`)
+
 type (
 	Client interface {
-		CreateChatCompletionStream(ctx context.Context, conv conversation.Conversation) (stream *openai.ChatCompletionStream, err error)
+		CreateChatCompletionStream(ctx context.Context, conv conversation.Conversation, opts ...RequestOption) (stream Stream, err error)
+
+		// CreateChatCompletion - ストリームを使わずに応答を生成します (要約等の内部処理向け)
+		CreateChatCompletion(ctx context.Context, conv conversation.Conversation, opts ...RequestOption) (content string, err error)
+
+		// VerifyModel - OpenAI互換のサーバーを利用する場合、設定されたモデルがサーバー上に存在するか確認します
+		VerifyModel(ctx context.Context) error
 	}
 
 	client struct {
-		oc     *openai.Client
-		model  string
-		logger logger.Logger
+		oc             *openai.Client
+		model          string
+		fallbackModels []string
+		baseURL        string
+		includeUsage   bool
+		prices         map[string]config.ModelPrice
+		retry          RetryPolicy
+		logger         logger.Logger
 	}
 )
 
-func (c *client) CreateChatCompletionStream(ctx context.Context, conv conversation.Conversation) (*openai.ChatCompletionStream, error) {
-	stream, err := c.oc.CreateChatCompletionStream(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model:    c.model,
-			Messages: conv.ToChatCompletionMessage(),
-		},
-	)
+func (c *client) CreateChatCompletionStream(ctx context.Context, conv conversation.Conversation, opts ...RequestOption) (Stream, error) {
+	options := newRequestOptions(opts)
+
+	var stream Stream
+	err := c.withRetry(ctx, options, func(ctx context.Context) error {
+		return c.withFallback(options, func(model string) error {
+			s, err := c.oc.CreateChatCompletionStream(ctx, c.newRequest(model, conv, options, true))
+			if err != nil {
+				return err
+			}
 
+			stream = newOpenAIStream(s, model, func(usage *Usage, completion string) {
+				c.reportUsage(options, model, conv, usage, completion)
+			})
+			return nil
+		})
+	})
 	if err != nil {
-		c.logger.Log(logger.WARN, "failed to create chat completion stream, try fallback to gpt4: %v", err)
-
-		// in 202311, gpt-4-1106-preview RPM may be extremely low. Try fallback only once.
-		stream, err = c.oc.CreateChatCompletionStream(
-			ctx,
-			openai.ChatCompletionRequest{
-				Model:    openai.GPT4,
-				Messages: conv.ToChatCompletionMessage(),
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+func (c *client) CreateChatCompletion(ctx context.Context, conv conversation.Conversation, opts ...RequestOption) (string, error) {
+	options := newRequestOptions(opts)
+
+	var content string
+	err := c.withRetry(ctx, options, func(ctx context.Context) error {
+		return c.withFallback(options, func(model string) error {
+			resp, err := c.oc.CreateChatCompletion(ctx, c.newRequest(model, conv, options, false))
+			if err != nil {
+				return err
+			}
+			if len(resp.Choices) == 0 {
+				return fmt.Errorf("no choices in response from %s", model)
+			}
+
+			content = resp.Choices[0].Message.Content
+			return nil
+		})
+	})
+
+	return content, err
+}
+
+// reportUsage - リクエストの使用量を記録して通知します
+// バックエンドが使用量を返さなかった場合は、送信した会話と受信した内容から見積もります
+func (c *client) reportUsage(options *requestOptions, model string, conv conversation.Conversation, usage *Usage, completion string) {
+	var record UsageRecord
+	if usage != nil {
+		record = newUsageRecord(model, usage.PromptTokens, usage.CompletionTokens, false, c.prices)
+	} else {
+		record = newUsageRecord(model, conv.Tokens(model), conversation.CountTokens(model, completion), true, c.prices)
+	}
+
+	c.logger.Log(logger.INFO, "usage: model=%s, prompt_tokens=%d, completion_tokens=%d, cost=$%.6f, estimated=%t",
+		record.Model, record.PromptTokens, record.CompletionTokens, record.Cost, record.Estimated)
+	options.onUsage(record)
+}
+
+// withRetry - レート制限やサーバーエラーで失敗した場合、時間をおいてattemptを再試行します
+func (c *client) withRetry(ctx context.Context, options *requestOptions, attempt func(ctx context.Context) error) error {
+	var waited time.Duration
+	for retry := 0; ; retry++ {
+		hint := &retryHint{}
+		err := attempt(withRetryHint(ctx, hint))
+		if err == nil {
+			return nil
+		}
+
+		if !isRetryable(err) || retry >= c.retry.MaxRetries {
+			return err
+		}
+
+		wait := c.retry.backoff(retry, hint.get())
+		if waited+wait > c.retry.MaxWait {
+			c.logger.Log(logger.WARN, "give up retrying: wait=%s exceeds max wait %s", waited+wait, c.retry.MaxWait)
+			return err
+		}
+		waited += wait
+
+		c.logger.Log(logger.WARN, "retry chat completion after %s (retry %d/%d): %v", wait, retry+1, c.retry.MaxRetries, err)
+		options.onWait(wait)
+
+		err = sleepContext(ctx, wait)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// withFallback - フォールバック先のモデルを順番に試しながらattemptを実行します
+func (c *client) withFallback(options *requestOptions, attempt func(model string) error) error {
+	models := c.candidateModels(options)
+
+	var err error
+	for i, model := range models {
+		c.logger.Log(logger.INFO, "create chat completion: model=%s, attempt=%d/%d", model, i+1, len(models))
+
+		err = attempt(model)
+		if err == nil {
+			return nil
+		}
+
+		err = classifyError(model, err)
+		if !shouldFallback(err) {
+			return err
+		}
+
+		c.logger.Log(logger.WARN, "failed to create chat completion with %s: %v", model, err)
+	}
+
+	return err
+}
+
+// candidateModels - 利用するモデルとフォールバック先のモデルを順番に返します
+func (c *client) candidateModels(options *requestOptions) []string {
+	var models []string
+	seen := make(map[string]bool)
+	if options.model != "" {
+		models = append(models, options.model)
+		seen[options.model] = true
+	}
+
+	for _, model := range append([]string{c.model}, c.fallbackModels...) {
+		if seen[model] {
+			continue
+		}
+		seen[model] = true
+		models = append(models, model)
+	}
+	return models
+}
+
+func (c *client) VerifyModel(ctx context.Context) error {
+	if c.baseURL == "" {
+		return nil
+	}
+
+	models, err := c.oc.ListModels(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list models on %s: %v", c.baseURL, err)
+	}
+
+	var available []string
+	for _, m := range models.Models {
+		if m.ID == c.model {
+			return nil
+		}
+		available = append(available, m.ID)
+	}
+
+	return fmt.Errorf("model %s is not found on %s (available: %s)", c.model, c.baseURL, strings.Join(available, ", "))
+}
+
+func (c *client) newRequest(model string, conv conversation.Conversation, options *requestOptions, stream bool) openai.ChatCompletionRequest {
+	req := openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: conv.ToChatCompletionMessage(model),
+	}
+
+	// フォールバック先のモデルがツールに対応していない場合もあるため、モデルごとに判定する
+	tools := options.tools
+	if !conversation.SupportsTools(model) {
+		tools = nil
+	}
+	for _, tool := range tools {
+		req.Tools = append(req.Tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
 			},
-		)
+		})
+	}
+
+	if options.temperature != nil && supportsTemperature(model) {
+		req.Temperature = *options.temperature
+
+		// 0はリクエストから省略されてモデルの既定値になってしまうため、ごく小さな値で代用する
+		if req.Temperature == 0 {
+			req.Temperature = math.SmallestNonzeroFloat32
+		}
 	}
 
-	return stream, err
+	if stream && c.includeUsage {
+		req.StreamOptions = &openai.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+
+	return req
+}
+
+// supportsTemperature - temperatureを指定できるモデルかどうかを判定します (推論モデルは既定値以外を受け付けません)
+func supportsTemperature(model string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return true
 }
 
-func ProvideGPTClient(config config.Config, logger logger.Logger) Client {
-	apiKey := config.OpenAIAPIKey()
-	orgID := config.OpenAIOrganizationID()
-	model := config.OpenAIModel()
+// newAzureClientConfig - Azure OpenAI向けのクライアント設定を作成します
+func newAzureClientConfig(cfg config.Config) openai.ClientConfig {
+	clientConfig := openai.DefaultAzureConfig(cfg.OpenAIAPIKey(), cfg.AzureOpenAIEndpoint())
+	clientConfig.APIVersion = cfg.AzureOpenAIAPIVersion()
 
-	clientConfig := openai.DefaultConfig(apiKey)
-	if orgID != "" {
-		clientConfig.OrgID = orgID
+	deployments := cfg.AzureOpenAIDeployments()
+	clientConfig.AzureModelMapperFunc = func(model string) string {
+		if deployment, ok := deployments[model]; ok {
+			return deployment
+		}
+
+		// デプロイメントが指定されていない場合はモデル名をそのまま利用する (Azureではモデル名に"."を使えない)
+		return azureDeploymentInvalidChars.ReplaceAllString(model, "")
+	}
+
+	return clientConfig
+}
+
+// supportsStreamUsage - Azure OpenAIのAPIバージョンがstream_optionsに対応しているかを、先頭の日付で判定します
+// "2024-10-01-preview" と "2024-10-21" のように接尾辞の有無が異なるため、文字列のままでは比較しません
+func supportsStreamUsage(apiVersion string) bool {
+	version, ok := parseAPIVersionDate(apiVersion)
+	if !ok {
+		return false
 	}
 
+	required, _ := parseAPIVersionDate(azureStreamUsageAPIVersion)
+	return !version.Before(required)
+}
+
+// parseAPIVersionDate - "2024-09-01-preview" のようなAPIバージョンの先頭の日付を取得します
+func parseAPIVersionDate(apiVersion string) (time.Time, bool) {
+	if len(apiVersion) < len("2006-01-02") {
+		return time.Time{}, false
+	}
+
+	date, err := time.Parse("2006-01-02", apiVersion[:len("2006-01-02")])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+func ProvideGPTClient(cfg config.Config, log logger.Logger) Client {
+	apiKey := cfg.OpenAIAPIKey()
+	orgID := cfg.OpenAIOrganizationID()
+	model := cfg.OpenAIModel()
+
+	var clientConfig openai.ClientConfig
+	var baseURL string
+	includeUsage := true
+	if cfg.OpenAIAPIType() == config.OpenAIAPITypeAzure {
+		clientConfig = newAzureClientConfig(cfg)
+
+		// stream_optionsに対応していないAPIバージョンではリクエスト自体が失敗する
+		includeUsage = supportsStreamUsage(cfg.AzureOpenAIAPIVersion())
+		log.Log(logger.INFO, "use azure openai: endpoint=%s, api_version=%s", cfg.AzureOpenAIEndpoint(), cfg.AzureOpenAIAPIVersion())
+	} else {
+		clientConfig = openai.DefaultConfig(apiKey)
+		if orgID != "" {
+			clientConfig.OrgID = orgID
+		}
+		if cfg.OpenAIBaseURL() != "" {
+			baseURL = strings.TrimSuffix(cfg.OpenAIBaseURL(), "/")
+			clientConfig.BaseURL = baseURL
+			log.Log(logger.INFO, "use openai compatible server: base_url=%s", baseURL)
+		}
+	}
+
+	clientConfig.HTTPClient = newRetryHTTPClient()
+
 	return &client{
-		oc:     openai.NewClientWithConfig(clientConfig),
-		model:  model,
-		logger: logger,
+		oc:             openai.NewClientWithConfig(clientConfig),
+		model:          model,
+		fallbackModels: cfg.OpenAIFallbackModels(),
+		baseURL:        baseURL,
+		includeUsage:   includeUsage,
+		prices:         cfg.ModelPrices(),
+		retry: RetryPolicy{
+			MaxRetries: cfg.OpenAIMaxRetries(),
+			MaxWait:    cfg.OpenAIRetryMaxWait(),
+		},
+		logger: log,
 	}
 }
@@ -0,0 +1,52 @@
+package gpt
+
+import (
+	"github.com/SGE-AI/sge-bot/conversation"
+	"testing"
+)
+
+func TestSupportsStreamUsage(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		want       bool
+	}{
+		{apiVersion: "2024-09-01-preview", want: true},
+		{apiVersion: "2024-10-21", want: true},
+		{apiVersion: "2025-04-01-preview", want: true},
+		{apiVersion: "2024-08-01-preview", want: false},
+		{apiVersion: "2024-06-01", want: false},
+		// 文字列として比較すると "2024-09-01" < "2024-09-01-preview" となってしまう
+		{apiVersion: "2024-09-01", want: true},
+		{apiVersion: "latest", want: false},
+		{apiVersion: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := supportsStreamUsage(tt.apiVersion); got != tt.want {
+			t.Errorf("supportsStreamUsage(%q) = %t, want %t", tt.apiVersion, got, tt.want)
+		}
+	}
+}
+
+func TestNewRequestTools(t *testing.T) {
+	tools := []ToolDefinition{{Name: "calculate", Parameters: []byte(`{"type":"object"}`)}}
+
+	tests := []struct {
+		model     string
+		wantTools int
+	}{
+		{model: "gpt-4o", wantTools: 1},
+		{model: "o3-mini", wantTools: 1},
+		{model: "o1-mini", wantTools: 0},
+		{model: "llama3:8b", wantTools: 0},
+	}
+
+	for _, tt := range tests {
+		c := &client{}
+		options := newRequestOptions([]RequestOption{WithTools(tools...)})
+		req := c.newRequest(tt.model, conversation.NewConversation(nil), options, true)
+		if len(req.Tools) != tt.wantTools {
+			t.Errorf("newRequest(%q) sent %d tools, want %d", tt.model, len(req.Tools), tt.wantTools)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+)
+
+var (
+	// ErrPromptFiltered - 入力がコンテンツフィルターによってブロックされた
+	ErrPromptFiltered = errors.New("prompt was filtered by content filter")
+
+	// ErrDeploymentNotFound - モデルに対応するAzure OpenAIのデプロイメントが存在しない
+	ErrDeploymentNotFound = errors.New("deployment not found")
+)
+
+// classifyError - APIのエラーをgptパッケージのエラーに変換します
+// 変換したエラーからも、errors.Asで元の*openai.APIErrorを取り出せます
+func classifyError(model string, err error) error {
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	code, _ := apiErr.Code.(string)
+	if code == "content_filter" || (apiErr.InnerError != nil && apiErr.InnerError.Code == "ResponsibleAIPolicyViolation") {
+		return fmt.Errorf("%w: %w", ErrPromptFiltered, err)
+	}
+
+	if code == "DeploymentNotFound" {
+		return fmt.Errorf("%w: model=%s: %w", ErrDeploymentNotFound, model, err)
+	}
+
+	return err
+}
+
+// shouldFallback - 別のモデルで再試行すべきエラーかどうかを判定します
+// レート制限、サーバーエラー、コンテキスト長超過、モデルが存在しない場合のみ対象とし、認証エラーやキャンセルは対象外とします
+func shouldFallback(err error) bool {
+	if errors.Is(err, ErrDeploymentNotFound) {
+		return true
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		code, _ := apiErr.Code.(string)
+		if code == "context_length_exceeded" || code == "model_not_found" {
+			return true
+		}
+		return isRetryableStatusCode(apiErr.HTTPStatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return isRetryableStatusCode(reqErr.HTTPStatusCode)
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		apiErr *openai.APIError
+		want   error
+	}{
+		{
+			name:   "content filter",
+			apiErr: &openai.APIError{Code: "content_filter", HTTPStatusCode: http.StatusBadRequest},
+			want:   ErrPromptFiltered,
+		},
+		{
+			name: "responsible ai policy violation",
+			apiErr: &openai.APIError{
+				HTTPStatusCode: http.StatusBadRequest,
+				InnerError:     &openai.InnerError{Code: "ResponsibleAIPolicyViolation"},
+			},
+			want: ErrPromptFiltered,
+		},
+		{
+			name:   "deployment not found",
+			apiErr: &openai.APIError{Code: "DeploymentNotFound", HTTPStatusCode: http.StatusNotFound},
+			want:   ErrDeploymentNotFound,
+		},
+		{
+			name:   "rate limit",
+			apiErr: &openai.APIError{Code: "rate_limit_exceeded", HTTPStatusCode: http.StatusTooManyRequests},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError("gpt-4o", fmt.Errorf("request failed: %w", tt.apiErr))
+
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("classifyError() = %v, want %v", err, tt.want)
+			}
+
+			var apiErr *openai.APIError
+			if !errors.As(err, &apiErr) || apiErr != tt.apiErr {
+				t.Errorf("classifyError() lost *openai.APIError: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package gpt
+
+import (
+	"context"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/conversation"
+	"io"
+	"sync"
+)
+
+type (
+	// FakeStream - あらかじめ用意したチャンクを順に返すStream (テスト用)
+	FakeStream struct {
+		// ModelName - Modelが返すモデル名
+		ModelName string
+
+		// Chunks - Recvで順に返すチャンク
+		Chunks []Chunk
+
+		// Err - すべてのチャンクを返した後にRecvが返すエラー。nilの場合はio.EOFを返します
+		Err error
+
+		next    int
+		closed  bool
+		onClose func(stream *FakeStream)
+	}
+
+	// FakeClient - あらかじめ用意したストリームを順に返すClient (テスト用)
+	FakeClient struct {
+		mu sync.Mutex
+
+		// Streams - CreateChatCompletionStreamで順に返すストリーム
+		Streams []*FakeStream
+
+		// StreamErrors - CreateChatCompletionStreamで順に返すエラー。nilでない場合はストリームの代わりに返します
+		StreamErrors []error
+
+		// Completion - CreateChatCompletionで返す内容
+		Completion string
+
+		// Requests - 受け付けたリクエスト
+		Requests []FakeRequest
+	}
+
+	// FakeRequest - FakeClientが受け付けたリクエストの内容
+	FakeRequest struct {
+		Conversation conversation.Conversation
+		Model        string
+		Temperature  *float32
+		Tools        []ToolDefinition
+		Stream       bool
+	}
+)
+
+// NewFakeStream - modelが応答したものとして、contentsを一つずつチャンクで返すストリームを作成します
+func NewFakeStream(model string, contents ...string) *FakeStream {
+	stream := &FakeStream{ModelName: model}
+	for _, content := range contents {
+		stream.Chunks = append(stream.Chunks, Chunk{Content: content})
+	}
+	return stream
+}
+
+func (s *FakeStream) Recv() (Chunk, error) {
+	if s.next < len(s.Chunks) {
+		s.next++
+		return s.Chunks[s.next-1], nil
+	}
+	if s.Err != nil {
+		return Chunk{}, s.Err
+	}
+	return Chunk{}, io.EOF
+}
+
+func (s *FakeStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.onClose != nil {
+		s.onClose(s)
+	}
+	return nil
+}
+
+func (s *FakeStream) Model() string {
+	return s.ModelName
+}
+
+// Closed - ストリームが閉じられたかどうかを返します
+func (s *FakeStream) Closed() bool {
+	return s.closed
+}
+
+// CreateChatCompletionStream - 用意したストリームを順に返します。ストリームを閉じた時点で、そのモデルの使用量を通知します
+func (c *FakeClient) CreateChatCompletionStream(ctx context.Context, conv conversation.Conversation, opts ...RequestOption) (Stream, error) {
+	options := c.record(conv, opts, true)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index := len(c.Requests) - 1
+	if index < len(c.StreamErrors) && c.StreamErrors[index] != nil {
+		return nil, c.StreamErrors[index]
+	}
+	if len(c.Streams) == 0 {
+		return nil, fmt.Errorf("no more fake streams (request %d)", index+1)
+	}
+
+	stream := c.Streams[0]
+	c.Streams = c.Streams[1:]
+	stream.onClose = func(s *FakeStream) {
+		options.onUsage(UsageRecord{Model: s.ModelName})
+	}
+	return stream, nil
+}
+
+func (c *FakeClient) CreateChatCompletion(ctx context.Context, conv conversation.Conversation, opts ...RequestOption) (string, error) {
+	options := c.record(conv, opts, false)
+	options.onUsage(UsageRecord{Model: options.model})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Completion, nil
+}
+
+func (c *FakeClient) VerifyModel(ctx context.Context) error {
+	return nil
+}
+
+// Request - i番目に受け付けたリクエストを返します
+func (c *FakeClient) Request(i int) FakeRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Requests[i]
+}
+
+func (c *FakeClient) record(conv conversation.Conversation, opts []RequestOption, stream bool) *requestOptions {
+	options := newRequestOptions(opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Requests = append(c.Requests, FakeRequest{
+		Conversation: conv.Clone(),
+		Model:        options.model,
+		Temperature:  options.temperature,
+		Tools:        options.tools,
+		Stream:       stream,
+	})
+	return options
+}
@@ -0,0 +1,76 @@
+package gpt
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type (
+	// RequestOption - リクエストごとに指定するオプション
+	RequestOption func(options *requestOptions)
+
+	requestOptions struct {
+		model       string
+		temperature *float32
+		onWait      func(wait time.Duration)
+		onUsage     func(usage UsageRecord)
+		tools       []ToolDefinition
+	}
+
+	// ToolDefinition - モデルに提示するツールの定義
+	ToolDefinition struct {
+		Name        string
+		Description string
+
+		// Parameters - 引数のJSON Schema
+		Parameters json.RawMessage
+	}
+)
+
+// WithWaitHandler - レート制限等で再試行を待つ間、待ち時間を通知する関数を指定します
+func WithWaitHandler(onWait func(wait time.Duration)) RequestOption {
+	return func(options *requestOptions) {
+		options.onWait = onWait
+	}
+}
+
+// WithUsageHandler - リクエストが終了するたびに、トークン使用量と料金を通知する関数を指定します
+// ストリームの場合は受信が終了した時点、または途中で閉じた時点で通知されます
+func WithUsageHandler(onUsage func(usage UsageRecord)) RequestOption {
+	return func(options *requestOptions) {
+		options.onUsage = onUsage
+	}
+}
+
+// WithModel - 設定されたモデルの代わりに利用するモデルを指定します
+// 指定したモデルで失敗した場合は、設定されたモデル、フォールバック先のモデルの順に試します
+func WithModel(model string) RequestOption {
+	return func(options *requestOptions) {
+		options.model = model
+	}
+}
+
+// WithTemperature - 回答のランダム性 (0〜2) を指定します。temperatureに対応していないモデルでは無視されます
+func WithTemperature(temperature float32) RequestOption {
+	return func(options *requestOptions) {
+		options.temperature = &temperature
+	}
+}
+
+// WithTools - モデルが呼び出すことができるツールを指定します
+func WithTools(tools ...ToolDefinition) RequestOption {
+	return func(options *requestOptions) {
+		options.tools = append(options.tools, tools...)
+	}
+}
+
+func newRequestOptions(opts []RequestOption) *requestOptions {
+	options := &requestOptions{
+		onWait:  func(time.Duration) {},
+		onUsage: func(UsageRecord) {},
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
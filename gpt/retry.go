@@ -0,0 +1,164 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 30 * time.Second
+)
+
+type (
+	// RetryPolicy - レート制限やサーバーエラー時の再試行方針
+	RetryPolicy struct {
+		// MaxRetries - 最大の再試行回数
+		MaxRetries int
+
+		// MaxWait - 再試行の待ち時間の合計の上限
+		MaxWait time.Duration
+	}
+
+	// retryHint - APIのレスポンスヘッダーから得られた次に再試行できるまでの時間
+	retryHint struct {
+		mu    sync.Mutex
+		after time.Duration
+	}
+
+	retryHintKey struct{}
+
+	// retryHintTransport - 失敗したレスポンスのヘッダーをretryHintに記録するhttp.RoundTripper
+	retryHintTransport struct {
+		base http.RoundTripper
+	}
+)
+
+// backoff - retry回目の再試行までの待ち時間を計算します
+// APIから待ち時間が指定されていればそれを優先し、なければジッター付きの指数バックオフを利用します
+func (p RetryPolicy) backoff(retry int, hint time.Duration) time.Duration {
+	if hint > 0 {
+		return hint + time.Duration(rand.Int63n(int64(500*time.Millisecond)))
+	}
+
+	delay := retryBaseDelay << retry
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// observe - 待ち時間を記録します。複数のレスポンスがあった場合は最も短いものを採用します
+func (h *retryHint) observe(after time.Duration) {
+	if after <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.after == 0 || after < h.after {
+		h.after = after
+	}
+}
+
+func (h *retryHint) get() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.after
+}
+
+func (t *retryHintTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if isRetryableStatusCode(resp.StatusCode) {
+		hint, ok := req.Context().Value(retryHintKey{}).(*retryHint)
+		if ok {
+			hint.observe(parseRetryAfter(resp.Header))
+		}
+	}
+
+	return resp, err
+}
+
+// parseRetryAfter - Retry-After, retry-after-ms, x-ratelimit-reset-* ヘッダーから待ち時間を取得します
+func parseRetryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+			return time.Duration(seconds * float64(time.Second))
+		}
+		// 過去の日時が返された場合は、下の x-ratelimit-reset-* を使う
+		if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+			return time.Until(date)
+		}
+	}
+
+	// x-ratelimit-reset-* は "1s", "6m0s", "20ms" のような形式で返される
+	var longest time.Duration
+	for _, key := range []string{"x-ratelimit-reset-requests", "x-ratelimit-reset-tokens"} {
+		d, err := time.ParseDuration(strings.TrimSpace(header.Get(key)))
+		if err == nil && d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// isRetryable - 時間をおいて再試行すべきエラーかどうかを判定します
+func isRetryable(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatusCode(apiErr.HTTPStatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return isRetryableStatusCode(reqErr.HTTPStatusCode)
+	}
+
+	return false
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+func withRetryHint(ctx context.Context, hint *retryHint) context.Context {
+	return context.WithValue(ctx, retryHintKey{}, hint)
+}
+
+// sleepContext - 指定した時間待機します。途中でコンテキストがキャンセルされた場合はすぐに戻ります
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func newRetryHTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &retryHintTransport{
+			base: http.DefaultTransport,
+		},
+	}
+}
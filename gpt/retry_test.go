@@ -0,0 +1,183 @@
+package gpt
+
+import (
+	"context"
+	"errors"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Log(level logger.LogLevel, format string, args ...interface{}) {}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    map[string]string
+		want      time.Duration
+		tolerance time.Duration
+	}{
+		{
+			name: "no header",
+			want: 0,
+		},
+		{
+			name:   "retry-after-ms",
+			header: map[string]string{"retry-after-ms": "1500"},
+			want:   1500 * time.Millisecond,
+		},
+		{
+			name:   "retry-after-ms takes precedence",
+			header: map[string]string{"retry-after-ms": "250", "Retry-After": "10"},
+			want:   250 * time.Millisecond,
+		},
+		{
+			name:   "retry-after seconds",
+			header: map[string]string{"Retry-After": "2"},
+			want:   2 * time.Second,
+		},
+		{
+			name:   "retry-after fractional seconds",
+			header: map[string]string{"Retry-After": "0.5"},
+			want:   500 * time.Millisecond,
+		},
+		{
+			name:      "retry-after http date",
+			header:    map[string]string{"Retry-After": time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat)},
+			want:      30 * time.Second,
+			tolerance: 2 * time.Second,
+		},
+		{
+			name:   "retry-after date in the past falls back to rate limit reset",
+			header: map[string]string{"Retry-After": "Wed, 21 Oct 2015 07:28:00 GMT", "x-ratelimit-reset-tokens": "3s"},
+			want:   3 * time.Second,
+		},
+		{
+			name:   "invalid retry-after",
+			header: map[string]string{"Retry-After": "soon"},
+			want:   0,
+		},
+		{
+			name:   "longest rate limit reset",
+			header: map[string]string{"x-ratelimit-reset-requests": "1s", "x-ratelimit-reset-tokens": "6m0s"},
+			want:   6 * time.Minute,
+		},
+		{
+			name:   "rate limit reset in milliseconds",
+			header: map[string]string{"x-ratelimit-reset-requests": "20ms"},
+			want:   20 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tt.header {
+				header.Set(key, value)
+			}
+
+			got := parseRetryAfter(header)
+			if got < tt.want-tt.tolerance || got > tt.want+tt.tolerance {
+				t.Errorf("parseRetryAfter() = %s, want %s (±%s)", got, tt.want, tt.tolerance)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	rateLimited := &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}
+	unauthorized := &openai.APIError{HTTPStatusCode: http.StatusUnauthorized}
+
+	tests := []struct {
+		name         string
+		policy       RetryPolicy
+		hint         time.Duration
+		failures     int
+		err          error
+		wantAttempts int
+		wantWaits    int
+		wantErr      bool
+	}{
+		{
+			name:         "success",
+			policy:       RetryPolicy{MaxRetries: 3, MaxWait: time.Minute},
+			wantAttempts: 1,
+		},
+		{
+			name:         "retry after hint",
+			policy:       RetryPolicy{MaxRetries: 3, MaxWait: time.Minute},
+			hint:         time.Millisecond,
+			failures:     1,
+			err:          rateLimited,
+			wantAttempts: 2,
+			wantWaits:    1,
+		},
+		{
+			name:         "give up when hint exceeds max wait",
+			policy:       RetryPolicy{MaxRetries: 3, MaxWait: 30 * time.Second},
+			hint:         time.Minute,
+			failures:     1,
+			err:          rateLimited,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "give up after max retries",
+			policy:       RetryPolicy{MaxRetries: 1, MaxWait: time.Minute},
+			hint:         time.Millisecond,
+			failures:     3,
+			err:          rateLimited,
+			wantAttempts: 2,
+			wantWaits:    1,
+			wantErr:      true,
+		},
+		{
+			name:         "not retryable",
+			policy:       RetryPolicy{MaxRetries: 3, MaxWait: time.Minute},
+			failures:     1,
+			err:          unauthorized,
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{retry: tt.policy, logger: nopLogger{}}
+
+			waits := 0
+			options := newRequestOptions([]RequestOption{WithWaitHandler(func(time.Duration) { waits++ })})
+
+			attempts := 0
+			err := c.withRetry(context.Background(), options, func(ctx context.Context) error {
+				attempts++
+				if attempts > tt.failures {
+					return nil
+				}
+
+				// レスポンスヘッダーから待ち時間を受け取った場合と同じように記録する
+				if hint, ok := ctx.Value(retryHintKey{}).(*retryHint); ok {
+					hint.observe(tt.hint)
+				}
+				return tt.err
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withRetry() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("withRetry() error = %v, want %v", err, tt.err)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if waits != tt.wantWaits {
+				t.Errorf("waits = %d, want %d", waits, tt.wantWaits)
+			}
+		})
+	}
+}
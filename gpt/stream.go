@@ -0,0 +1,139 @@
+package gpt
+
+import (
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+const (
+	FinishReasonStop          FinishReason = "stop"
+	FinishReasonLength        FinishReason = "length"
+	FinishReasonContentFilter FinishReason = "content_filter"
+	FinishReasonToolCalls     FinishReason = "tool_calls"
+)
+
+type (
+	// Stream - モデルの応答を逐次受け取るストリーム
+	Stream interface {
+		// Recv - 次のチャンクを受け取ります。応答が終了した場合はio.EOFを返します
+		Recv() (Chunk, error)
+
+		// Close - ストリームを閉じます
+		Close() error
+
+		// Model - 実際に応答しているモデル名を取得します
+		Model() string
+	}
+
+	// Chunk - ストリームから受け取った応答の断片
+	Chunk struct {
+		// Content - 追加されたテキスト
+		Content string
+
+		// FinishReason - 応答が終了した理由 (終了していない場合は空文字)
+		FinishReason FinishReason
+
+		// Usage - トークン使用量 (バックエンドが返した場合のみ)
+		Usage *Usage
+
+		// ToolCalls - ツール呼び出しの断片
+		ToolCalls []ToolCallDelta
+	}
+
+	// ToolCallDelta - ストリームで分割されて届くツール呼び出しの断片
+	// 同じIndexの断片を順に連結することで一つのツール呼び出しになります
+	ToolCallDelta struct {
+		Index     int
+		ID        string
+		Name      string
+		Arguments string
+	}
+
+	FinishReason string
+
+	// Usage - 一回のリクエストで使用したトークン数
+	Usage struct {
+		PromptTokens     int
+		CompletionTokens int
+		TotalTokens      int
+	}
+
+	openAIStream struct {
+		stream *openai.ChatCompletionStream
+		model  string
+
+		// completion - 使用量が返されなかった場合に手元で数えるための、受信した内容
+		completion strings.Builder
+		usage      *Usage
+
+		// onFinish - ストリームの終了時 (受信の終了またはClose) に一度だけ呼び出されます
+		onFinish func(usage *Usage, completion string)
+		finished bool
+	}
+)
+
+func (s *openAIStream) Recv() (Chunk, error) {
+	resp, err := s.stream.Recv()
+	if err != nil {
+		s.finish()
+		return Chunk{}, err
+	}
+
+	var chunk Chunk
+	if len(resp.Choices) > 0 {
+		chunk.Content = resp.Choices[0].Delta.Content
+		chunk.FinishReason = FinishReason(resp.Choices[0].FinishReason)
+
+		for i, call := range resp.Choices[0].Delta.ToolCalls {
+			index := i
+			if call.Index != nil {
+				index = *call.Index
+			}
+			chunk.ToolCalls = append(chunk.ToolCalls, ToolCallDelta{
+				Index:     index,
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+			s.completion.WriteString(call.Function.Name + call.Function.Arguments)
+		}
+		s.completion.WriteString(chunk.Content)
+	}
+
+	if resp.Usage != nil {
+		chunk.Usage = &Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+		}
+		s.usage = chunk.Usage
+	}
+
+	return chunk, nil
+}
+
+func (s *openAIStream) Close() error {
+	// 途中で閉じた場合も、それまでに生成された分の料金はかかる
+	s.finish()
+	return s.stream.Close()
+}
+
+func (s *openAIStream) finish() {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.onFinish(s.usage, s.completion.String())
+}
+
+func (s *openAIStream) Model() string {
+	return s.model
+}
+
+func newOpenAIStream(stream *openai.ChatCompletionStream, model string, onFinish func(usage *Usage, completion string)) Stream {
+	return &openAIStream{
+		stream:   stream,
+		model:    model,
+		onFinish: onFinish,
+	}
+}
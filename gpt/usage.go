@@ -0,0 +1,105 @@
+package gpt
+
+import (
+	"github.com/SGE-AI/sge-bot/config"
+	"strings"
+)
+
+type (
+	// UsageRecord - リクエストのトークン使用量と料金
+	UsageRecord struct {
+		// Model - 実際に応答したモデル
+		Model string
+
+		PromptTokens     int
+		CompletionTokens int
+
+		// Cost - 料金 (USD)
+		Cost float64
+
+		// Estimated - バックエンドが使用量を返さず、手元で数えた値を含む場合はtrue
+		Estimated bool
+	}
+
+	priceEntry struct {
+		prefix string
+		price  config.ModelPrice
+	}
+)
+
+// prices - モデル名の前方一致で料金 (100万トークンあたりのUSD) を決定します。上にあるものが優先されます
+var prices = []priceEntry{
+	{prefix: "gpt-5-nano", price: config.ModelPrice{Input: 0.05, Output: 0.40}},
+	{prefix: "gpt-5-mini", price: config.ModelPrice{Input: 0.25, Output: 2.00}},
+	{prefix: "gpt-5", price: config.ModelPrice{Input: 1.25, Output: 10.00}},
+	{prefix: "gpt-4.1-nano", price: config.ModelPrice{Input: 0.10, Output: 0.40}},
+	{prefix: "gpt-4.1-mini", price: config.ModelPrice{Input: 0.40, Output: 1.60}},
+	{prefix: "gpt-4.1", price: config.ModelPrice{Input: 2.00, Output: 8.00}},
+	{prefix: "gpt-4.5", price: config.ModelPrice{Input: 75.00, Output: 150.00}},
+	{prefix: "gpt-4o-mini", price: config.ModelPrice{Input: 0.15, Output: 0.60}},
+	{prefix: "gpt-4o", price: config.ModelPrice{Input: 2.50, Output: 10.00}},
+	{prefix: "chatgpt-4o", price: config.ModelPrice{Input: 5.00, Output: 15.00}},
+	{prefix: "gpt-4-turbo", price: config.ModelPrice{Input: 10.00, Output: 30.00}},
+	{prefix: "gpt-4-1106", price: config.ModelPrice{Input: 10.00, Output: 30.00}},
+	{prefix: "gpt-4-0125", price: config.ModelPrice{Input: 10.00, Output: 30.00}},
+	{prefix: "gpt-4-32k", price: config.ModelPrice{Input: 60.00, Output: 120.00}},
+	{prefix: "gpt-4", price: config.ModelPrice{Input: 30.00, Output: 60.00}},
+	{prefix: "gpt-3.5-turbo", price: config.ModelPrice{Input: 0.50, Output: 1.50}},
+	{prefix: "o1-mini", price: config.ModelPrice{Input: 1.10, Output: 4.40}},
+	{prefix: "o1", price: config.ModelPrice{Input: 15.00, Output: 60.00}},
+	{prefix: "o3-mini", price: config.ModelPrice{Input: 1.10, Output: 4.40}},
+	{prefix: "o3", price: config.ModelPrice{Input: 2.00, Output: 8.00}},
+	{prefix: "o4-mini", price: config.ModelPrice{Input: 1.10, Output: 4.40}},
+}
+
+// priceForModel - モデルの料金を取得します。overridesにモデル名が含まれている場合は組み込みの料金表よりも優先します
+// 料金が分からないモデルの場合はfalseを返します
+func priceForModel(model string, overrides map[string]config.ModelPrice) (config.ModelPrice, bool) {
+	if price, ok := overrides[model]; ok {
+		return price, true
+	}
+
+	for _, entry := range prices {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.price, true
+		}
+	}
+	return config.ModelPrice{}, false
+}
+
+// Add - 複数のリクエストの使用量を合計します。モデルは後から追加したものを残します
+func (u UsageRecord) Add(other UsageRecord) UsageRecord {
+	model := u.Model
+	if other.Model != "" {
+		model = other.Model
+	}
+
+	return UsageRecord{
+		Model:            model,
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		Cost:             u.Cost + other.Cost,
+		Estimated:        u.Estimated || other.Estimated,
+	}
+}
+
+// EstimateUsage - トークン数から料金を計算して、推定の使用量を作成します
+// 回答の前に利用上限の分を確保する場合など、実際の使用量が分かる前に利用します
+func EstimateUsage(model string, promptTokens int, completionTokens int, overrides map[string]config.ModelPrice) UsageRecord {
+	return newUsageRecord(model, promptTokens, completionTokens, true, overrides)
+}
+
+// newUsageRecord - トークン数から料金を計算して使用量を作成します
+func newUsageRecord(model string, promptTokens int, completionTokens int, estimated bool, overrides map[string]config.ModelPrice) UsageRecord {
+	record := UsageRecord{
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Estimated:        estimated,
+	}
+
+	if price, ok := priceForModel(model, overrides); ok {
+		record.Cost = (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1_000_000
+	}
+	return record
+}
@@ -0,0 +1,131 @@
+package interfaces
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/usecase"
+	"github.com/slack-go/slack"
+	"strings"
+)
+
+const (
+	CommandHelpMessage = "*`/gpt` の使い方*\n" +
+		"• `/gpt ask <質問>` ボットに質問します (チャンネルに質問が投稿され、そのスレッドに回答します)\n" +
+		"• `/gpt usage` あなたの利用状況を表示します\n" +
+		"• `/gpt settings` あなたの個人設定とボットの設定を表示します\n" +
+		"• `/gpt model` 利用できるモデルの一覧を表示します\n" +
+		"• `/gpt help` この使い方を表示します\n" +
+		"ボットにメンションするか、DMを送ることでも会話できます。"
+
+	OnAskWithoutPromptMessage = "質問を入力してください。例: `/gpt ask Goのgoroutineについて教えて`"
+
+	OnAskFailedMessage = "質問を投稿できませんでした。ボットをこのチャンネルに招待してから、もう一度お試しください。"
+
+	OnStatisticsDisabledMessage = "このボットでは利用状況を記録していません。"
+)
+
+// HandleSlashCommand - /gptコマンドを受け取り、サブコマンドに応じて処理します
+func (e eventHandler) HandleSlashCommand(cmd slack.SlashCommand) error {
+	subcommand, args, _ := strings.Cut(strings.TrimSpace(cmd.Text), " ")
+	args = strings.TrimSpace(args)
+
+	e.logger.Log(logger.INFO, "slash command userid: %s, subcommand: %s", cmd.UserID, subcommand)
+	switch subcommand {
+	case "ask":
+		if args == "" {
+			return e.api.RespondEphemeral(cmd.ResponseURL, OnAskWithoutPromptMessage)
+		}
+
+		go e.stat.UsedBy(cmd.UserID)
+		// 質問を投稿できた後の失敗は、スレッドの回答に表示される
+		err := e.chat.StartPromptConversation(cmd.ChannelID, cmd.UserID, args)
+		if errors.Is(err, usecase.ErrCannotPost) {
+			_ = e.api.RespondEphemeral(cmd.ResponseURL, OnAskFailedMessage)
+		}
+		return err
+	case "usage":
+		return e.api.RespondEphemeral(cmd.ResponseURL, e.usageMessage(cmd.UserID))
+	case "settings":
+		return e.api.RespondEphemeral(cmd.ResponseURL, e.settingsMessage(cmd.UserID))
+	case "model":
+		return e.api.RespondEphemeral(cmd.ResponseURL, e.modelMessage())
+	case "", "help":
+		return e.api.RespondEphemeral(cmd.ResponseURL, CommandHelpMessage)
+	default:
+		return e.api.RespondEphemeral(cmd.ResponseURL, fmt.Sprintf("不明なサブコマンドです: `%s`\n\n%s", subcommand, CommandHelpMessage))
+	}
+}
+
+// usageMessage - ユーザーの利用状況を表示するメッセージを作成します
+func (e eventHandler) usageMessage(userID string) string {
+	stat, err := e.stat.Get(userID)
+	if errors.Is(err, usecase.ErrStatisticsDisabled) {
+		return OnStatisticsDisabledMessage
+	} else if err != nil {
+		e.logger.Log(logger.ERROR, "failed to get statistics: %v", err)
+		return "利用状況を取得できませんでした。しばらく時間をおいてから、もう一度お試しください。"
+	}
+
+	lastUsed := stat.LastUsed
+	if lastUsed == "" {
+		lastUsed = "-"
+	}
+	lastModel := stat.LastModel
+	if lastModel == "" {
+		lastModel = "-"
+	}
+
+	return fmt.Sprintf("*<@%s> さんの利用状況*\n"+
+		"• 利用回数: %d 回\n"+
+		"• 最終利用日時: %s\n"+
+		"• 最後に応答したモデル: %s\n"+
+		"• 入力トークン数の累計: %d\n"+
+		"• 出力トークン数の累計: %d\n"+
+		"• 料金の累計: $%.4f",
+		userID, stat.UseCount, lastUsed, lastModel, stat.PromptTokens, stat.CompletionTokens, stat.Cost)
+}
+
+// settingsMessage - ユーザーの個人設定と、ボット全体の設定を表示するメッセージを作成します
+func (e eventHandler) settingsMessage(userID string) string {
+	instructions := e.settings.Get(userID).Instructions
+	if instructions == "" {
+		instructions = "_未設定_"
+	} else {
+		instructions = "\n> " + strings.ReplaceAll(instructions, "\n", "\n> ")
+	}
+
+	fallback := strings.Join(e.config.OpenAIFallbackModels(), ", ")
+	if fallback == "" {
+		fallback = "なし"
+	}
+	tools := "無効"
+	if e.config.ToolsEnabled() {
+		tools = "有効"
+	}
+
+	return fmt.Sprintf("*<@%s> さんの個人設定*\n"+
+		"%s\n"+
+		"*個人的な指示*: %s\n"+
+		"個人設定はボットのHomeタブから変更できます。\n\n"+
+		"*ボットの設定*\n"+
+		"• モデル: %s\n"+
+		"• フォールバック先のモデル: %s\n"+
+		"• ツールの呼び出し: %s\n"+
+		"• 会話のトークン数の上限: %d",
+		userID, e.personalSettingsText(userID), instructions,
+		e.config.OpenAIModel(), fallback, tools, e.config.MaxPromptTokens())
+}
+
+// modelMessage - 利用できるモデルの一覧を表示するメッセージを作成します
+func (e eventHandler) modelMessage() string {
+	lines := []string{"*利用できるモデル*"}
+	for _, model := range e.config.AllowedModels() {
+		line := "• " + model
+		if model == e.config.OpenAIModel() {
+			line += " (デフォルト)"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
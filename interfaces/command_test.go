@@ -0,0 +1,71 @@
+package interfaces
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/usecase"
+	"github.com/slack-go/slack"
+	"testing"
+)
+
+type (
+	fakeChat struct {
+		usecase.Chat
+		err error
+	}
+
+	fakeStatistics struct {
+		usecase.Statistics
+	}
+
+	fakeSlackAPI struct {
+		slackapi.SlackAPI
+		ephemerals []string
+	}
+)
+
+func (c fakeChat) StartPromptConversation(channelID string, userID string, prompt string) error {
+	return c.err
+}
+
+func (fakeStatistics) UsedBy(SlackUserID string) {}
+
+func (a *fakeSlackAPI) RespondEphemeral(responseURL string, msg string) error {
+	a.ephemerals = append(a.ephemerals, msg)
+	return nil
+}
+
+func TestHandleSlashCommandAsk(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantEphemeral bool
+	}{
+		{name: "answered"},
+		{name: "cannot post", err: fmt.Errorf("%w: not_in_channel", usecase.ErrCannotPost), wantEphemeral: true},
+		{name: "failed after posting", err: errors.New("failed to create chat completion stream")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeSlackAPI{}
+			e := eventHandler{
+				logger: nopLogger{},
+				api:    api,
+				chat:   fakeChat{err: tt.err},
+				stat:   fakeStatistics{},
+			}
+
+			err := e.HandleSlashCommand(slack.SlashCommand{ChannelID: "C0001", UserID: "U0001", Text: "ask 質問です"})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("HandleSlashCommand() error = %v, want %v", err, tt.err)
+			}
+
+			// 質問を投稿できなかった場合だけ、ボットの招待を案内する
+			if got := len(api.ephemerals) == 1 && api.ephemerals[0] == OnAskFailedMessage; got != tt.wantEphemeral {
+				t.Errorf("ephemerals = %q", api.ephemerals)
+			}
+		})
+	}
+}
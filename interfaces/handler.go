@@ -3,6 +3,8 @@ package interfaces
 import (
 	"github.com/SGE-AI/sge-bot/config"
 	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/SGE-AI/sge-bot/slackapi"
 	"github.com/SGE-AI/sge-bot/usecase"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
@@ -13,14 +15,22 @@ type (
 		HandleAppMentionEvent(innerEvent slackevents.AppMentionEvent) error
 		HandleMessageEvent(innerEvent slackevents.MessageEvent) error
 		HandleBlockActionsEvent(innerEvent slack.InteractionCallback) error
+		HandleSlashCommand(cmd slack.SlashCommand) error
+		HandleMessageShortcut(event slack.InteractionCallback) error
+		HandleViewSubmission(event slack.InteractionCallback) error
+		HandleAppHomeOpened(event slackevents.AppHomeOpenedEvent) error
 	}
 
 	eventHandler struct {
-		config config.Config
-		logger logger.Logger
-		slack  slack.Client
-		chat   usecase.Chat
-		stat   usecase.Statistics
+		config   config.Config
+		logger   logger.Logger
+		slack    slack.Client
+		api      slackapi.SlackAPI
+		chat     usecase.Chat
+		stat     usecase.Statistics
+		quota    usecase.Quota
+		settings usecase.Settings
+		events   repository.EventRepository
 	}
 )
 
@@ -38,30 +48,50 @@ func (e eventHandler) HandleMessageEvent(event slackevents.MessageEvent) error {
 		return nil
 	}
 
+	if !e.firstDelivery(event.Channel, event.TimeStamp, event.ClientMsgID) {
+		e.logger.Log(logger.VERB, "skip duplicated message event: %s", event.TimeStamp)
+		return nil
+	}
+
 	ts := event.ThreadTimeStamp
 	if ts == "" {
 		ts = event.TimeStamp
 	}
 
+	handled, err := e.quota.HandleCommand(event.Channel, ts, event.User, event.Text)
+	if handled {
+		return err
+	}
+
 	e.logger.Log(logger.INFO, "start normal conversation userid by message event: %s", event.User)
 	go e.stat.UsedBy(event.User)
-	return e.chat.StartNormalConversation(event.Channel, ts)
+	return e.chat.StartNormalConversation(event.Channel, ts, event.User)
 }
 
 // HandleAppMentionEvent - メンションを受け取り、会話を開始します (チャンネル向け)
 func (e eventHandler) HandleAppMentionEvent(event slackevents.AppMentionEvent) error {
+	if !e.firstDelivery(event.Channel, event.TimeStamp, "") {
+		e.logger.Log(logger.VERB, "skip duplicated app mention event: %s", event.TimeStamp)
+		return nil
+	}
+
 	ts := event.ThreadTimeStamp
 	if ts == "" {
 		ts = event.TimeStamp
 	}
 
+	handled, err := e.quota.HandleCommand(event.Channel, ts, event.User, event.Text)
+	if handled {
+		return err
+	}
+
 	e.logger.Log(logger.INFO, "start normal conversation userid by app mention event: %s", event.User)
 
 	go e.stat.UsedBy(event.User)
-	return e.chat.StartNormalConversation(event.Channel, ts)
+	return e.chat.StartNormalConversation(event.Channel, ts, event.User)
 }
 
-// HandleBlockActionsEvent - ブロックアクションを受け取り、会話を再生成します
+// HandleBlockActionsEvent - ブロックアクションを受け取り、会話の再生成や個人設定の編集を行います
 func (e eventHandler) HandleBlockActionsEvent(event slack.InteractionCallback) error {
 	if event.Type != slack.InteractionTypeBlockActions {
 		return nil
@@ -70,13 +100,17 @@ func (e eventHandler) HandleBlockActionsEvent(event slack.InteractionCallback) e
 	for _, action := range event.ActionCallback.BlockActions {
 		if action.ActionID == "regenerate" {
 			e.logger.Log(logger.INFO, "regenerate message userid: %s, timestamp: %s", event.User.ID, action.BlockID)
-			return e.chat.RegenerateMessage(event.Channel.ID, action.BlockID, event.Container.ThreadTs, event.Container.MessageTs)
+			parts := slackapi.ParsePartTimeStamps(action.Value, action.BlockID)
+			return e.chat.RegenerateMessage(event.Channel.ID, action.BlockID, event.Container.ThreadTs, event.Container.MessageTs, event.User.ID, parts)
 		} else if action.ActionID == "stop" {
 			e.logger.Log(logger.INFO, "stop message userid: %s, timestamp: %s", event.User.ID, action.BlockID)
 			return e.chat.StopGenerateMessage(event.Channel.ID, action.BlockID, event.Container.MessageTs)
 		} else if action.ActionID == "delete" {
 			e.logger.Log(logger.INFO, "delete message userid: %s, timestamp: %s", event.User.ID, action.BlockID)
-			return e.chat.DeleteMessage(event.Channel.ID, action.BlockID, event.Container.MessageTs)
+			parts := slackapi.ParsePartTimeStamps(action.Value, action.BlockID)
+			return e.chat.DeleteMessage(event.Channel.ID, action.BlockID, event.Container.ThreadTs, event.Container.MessageTs, parts)
+		} else if action.ActionID == slackapi.EditSettingsActionID || action.ActionID == slackapi.EditInstructionsActionID {
+			return e.openSettingsModal(event)
 		} else {
 			e.logger.Log(logger.INFO, "unknown action: %s", action.ActionID)
 		}
@@ -85,11 +119,25 @@ func (e eventHandler) HandleBlockActionsEvent(event slack.InteractionCallback) e
 	return nil
 }
 
-func ProvideEventHandler(config config.Config, log logger.Logger, chat usecase.Chat, stat usecase.Statistics) EventHandler {
+// firstDelivery - メッセージに対するイベントを初めて受け取ったかどうかを判定します
+// 再送された場合や、DMでのメンションがapp_mentionとmessageの両方で届いた場合に、二度回答しないようにします
+func (e eventHandler) firstDelivery(channelID string, ts string, clientMsgID string) bool {
+	first := e.events.MarkProcessed("message:" + channelID + ":" + ts)
+	if clientMsgID != "" {
+		first = e.events.MarkProcessed("client_msg:"+clientMsgID) && first
+	}
+	return first
+}
+
+func ProvideEventHandler(config config.Config, log logger.Logger, chat usecase.Chat, stat usecase.Statistics, quota usecase.Quota, settings usecase.Settings, events repository.EventRepository, api slackapi.SlackAPI) EventHandler {
 	return &eventHandler{
-		config: config,
-		logger: log,
-		chat:   chat,
-		stat:   stat,
+		config:   config,
+		logger:   log,
+		api:      api,
+		chat:     chat,
+		stat:     stat,
+		quota:    quota,
+		settings: settings,
+		events:   events,
 	}
 }
@@ -0,0 +1,131 @@
+package interfaces
+
+import (
+	"fmt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/usecase"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"strconv"
+)
+
+// HandleAppHomeOpened - Homeタブが開かれたときに、ユーザーごとの内容を表示します
+func (e eventHandler) HandleAppHomeOpened(event slackevents.AppHomeOpenedEvent) error {
+	if event.Tab != "home" {
+		return nil
+	}
+
+	return e.publishHome(event.User)
+}
+
+// publishHome - ユーザーの利用状況と個人設定をHomeタブに表示します
+func (e eventHandler) publishHome(userID string) error {
+	return e.api.PublishHomeView(userID, slackapi.HomeView{
+		Usage:        e.usageMessage(userID),
+		Settings:     e.personalSettingsText(userID),
+		Instructions: e.settings.Get(userID).Instructions,
+	})
+}
+
+// personalSettingsText - ユーザーの個人設定 (個人的な指示を除く) の一覧を作成します
+func (e eventHandler) personalSettingsText(userID string) string {
+	settings := e.settings.Get(userID)
+
+	model := e.settings.Model(userID)
+	if model == "" {
+		model = e.config.OpenAIModel() + " (ボットの既定)"
+	}
+
+	return fmt.Sprintf("*既定のモデル*: %s\n"+
+		"*回答の言語*: %s\n"+
+		"*回答のスタイル*: %s\n"+
+		"*temperature*: %s",
+		model,
+		choiceLabel(usecase.Languages, settings.Language, "質問と同じ言語"),
+		choiceLabel(usecase.AnswerStyles, settings.AnswerStyle, "指定しない"),
+		temperatureLabel(settings.Temperature))
+}
+
+// openSettingsModal - 個人設定を編集するモーダルを開きます
+func (e eventHandler) openSettingsModal(event slack.InteractionCallback) error {
+	settings := e.settings.Get(event.User.ID)
+
+	var models []slackapi.SettingsChoice
+	for _, model := range e.config.AllowedModels() {
+		models = append(models, slackapi.SettingsChoice{Value: model, Label: model})
+	}
+
+	temperature := ""
+	if settings.Temperature != nil {
+		temperature = temperatureLabel(settings.Temperature)
+	}
+
+	e.logger.Log(logger.INFO, "open settings modal userid: %s", event.User.ID)
+	return e.api.OpenSettingsModal(event.TriggerID, slackapi.SettingsForm{
+		Models:                models,
+		Languages:             settingsChoices(usecase.Languages),
+		AnswerStyles:          settingsChoices(usecase.AnswerStyles),
+		Temperatures:          settingsChoices(usecase.Temperatures),
+		DefaultModel:          e.config.OpenAIModel(),
+		Model:                 settings.Model,
+		Language:              settings.Language,
+		AnswerStyle:           settings.AnswerStyle,
+		Temperature:           temperature,
+		Instructions:          settings.Instructions,
+		MaxInstructionsLength: usecase.MaxPersonalInstructionsLength,
+	})
+}
+
+// saveSettings - 個人設定のモーダルの送信内容を保存し、Homeタブを更新します
+func (e eventHandler) saveSettings(event slack.InteractionCallback) error {
+	form := slackapi.ParseSettingsModalSubmission(event.View)
+
+	settings := repository.UserSettings{
+		Model:        form.Model,
+		Language:     form.Language,
+		AnswerStyle:  form.AnswerStyle,
+		Instructions: form.Instructions,
+	}
+	if form.Temperature != "" {
+		temperature, err := strconv.ParseFloat(form.Temperature, 32)
+		if err != nil {
+			return fmt.Errorf("failed to parse temperature: %v", err)
+		}
+		t := float32(temperature)
+		settings.Temperature = &t
+	}
+
+	err := e.settings.Update(event.User.ID, settings)
+	if err != nil {
+		return err
+	}
+
+	return e.publishHome(event.User.ID)
+}
+
+func settingsChoices(choices []usecase.SettingChoice) []slackapi.SettingsChoice {
+	var converted []slackapi.SettingsChoice
+	for _, choice := range choices {
+		converted = append(converted, slackapi.SettingsChoice{Value: choice.Value, Label: choice.Label})
+	}
+	return converted
+}
+
+// choiceLabel - 選択された値の表示名を返します。選択されていない場合はfallbackを返します
+func choiceLabel(choices []usecase.SettingChoice, value string, fallback string) string {
+	for _, choice := range choices {
+		if choice.Value == value {
+			return choice.Label
+		}
+	}
+	return fallback
+}
+
+func temperatureLabel(temperature *float32) string {
+	if temperature == nil {
+		return "モデルの既定"
+	}
+	return strconv.FormatFloat(float64(*temperature), 'f', 1, 32)
+}
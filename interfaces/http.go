@@ -0,0 +1,157 @@
+package interfaces
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"io"
+	"net/http"
+)
+
+const (
+	// EventsPath, InteractivityPath, CommandsPath - SlackアプリのEvent Subscriptions、Interactivity、Slash Commandsに設定するリクエストURLのパス
+	EventsPath        = "/slack/events"
+	InteractivityPath = "/slack/interactivity"
+	CommandsPath      = "/slack/commands"
+
+	// maxRequestBodyBytes - 受け付けるリクエストボディのサイズの上限
+	maxRequestBodyBytes = 1024 * 1024
+)
+
+// httpConnection - Events APIとInteractivityのリクエストをHTTPで受け取ります
+// HTTPサーバー自体はmainでPORTに起動されるため、ここではリクエストを振り分けるハンドラーを用意するだけです
+type httpConnection struct {
+	signingSecret string
+	router        eventRouter
+	logger        logger.Logger
+	mux           *http.ServeMux
+}
+
+func newHTTPConnection(signingSecret string, router eventRouter, log logger.Logger) *httpConnection {
+	h := &httpConnection{
+		signingSecret: signingSecret,
+		router:        router,
+		logger:        log,
+		mux:           http.NewServeMux(),
+	}
+	h.mux.HandleFunc(EventsPath, h.handleEvents)
+	h.mux.HandleFunc(InteractivityPath, h.handleInteractivity)
+	h.mux.HandleFunc(CommandsPath, h.handleCommands)
+	return h
+}
+
+// Handler - Slackからのリクエストを受け付けるハンドラー。mainでHTTPサーバーを起動する前に登録します
+func (h *httpConnection) Handler() http.Handler {
+	return h.mux
+}
+
+// Run - リクエストを待ち受けます。リクエストはHandlerで処理されるため、ここでは終了させないためにブロックするだけです
+func (h *httpConnection) Run() error {
+	h.logger.Log(logger.INFO, "waiting for slack requests on %s, %s and %s", EventsPath, InteractivityPath, CommandsPath)
+
+	select {}
+}
+
+// handleEvents - Events APIのリクエストを処理します
+func (h *httpConnection) handleEvents(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.verify(w, r)
+	if !ok {
+		return
+	}
+
+	eventsAPIEvent, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
+	if err != nil {
+		h.logger.Log(logger.WARN, "failed to parse events api request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if eventsAPIEvent.Type == slackevents.URLVerification {
+		var challenge slackevents.ChallengeResponse
+		err = json.Unmarshal(body, &challenge)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(challenge.Challenge))
+		return
+	}
+
+	// 3秒以内に応答しないと再送されるため、先に応答してから処理する
+	w.WriteHeader(http.StatusOK)
+	go h.router.routeEventsAPI(eventsAPIEvent)
+}
+
+// handleInteractivity - ボタン等の操作のリクエストを処理します
+func (h *httpConnection) handleInteractivity(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.verify(w, r)
+	if !ok {
+		return
+	}
+
+	var event slack.InteractionCallback
+	err := json.Unmarshal([]byte(r.FormValue("payload")), &event)
+	if err != nil {
+		h.logger.Log(logger.WARN, "failed to parse interaction payload: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	go h.router.routeInteraction(event)
+}
+
+// handleCommands - スラッシュコマンドのリクエストを処理します
+func (h *httpConnection) handleCommands(w http.ResponseWriter, r *http.Request) {
+	_, ok := h.verify(w, r)
+	if !ok {
+		return
+	}
+
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		h.logger.Log(logger.WARN, "failed to parse slash command: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	go h.router.routeSlashCommand(cmd)
+}
+
+// verify - リクエストの署名を検証し、ボディを返します。検証に失敗した場合はエラーを応答します
+// 検証後もFormValueで読めるように、リクエストのボディは読み直せる状態に戻します
+func (h *httpConnection) verify(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	verifier, err := slack.NewSecretsVerifier(r.Header, h.signingSecret)
+	if err != nil {
+		h.logger.Log(logger.WARN, "failed to create secrets verifier: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodyBytes))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	_, _ = verifier.Write(body)
+	err = verifier.Ensure()
+	if err != nil {
+		h.logger.Log(logger.WARN, "invalid slack signature: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, false
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
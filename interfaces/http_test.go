@@ -0,0 +1,139 @@
+package interfaces
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/slack-go/slack"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "test-signing-secret"
+
+type (
+	fakeEventHandler struct {
+		EventHandler
+		commands chan slack.SlashCommand
+	}
+
+	nopLogger struct{}
+)
+
+func (h fakeEventHandler) HandleSlashCommand(cmd slack.SlashCommand) error {
+	h.commands <- cmd
+	return nil
+}
+
+func (nopLogger) Log(level logger.LogLevel, format string, args ...interface{}) {}
+
+// signedRequest - Slackと同じ方法で署名したリクエストを作成します
+func signedRequest(t *testing.T, target string, contentType string, body string, secret string) *http.Request {
+	t.Helper()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	req, err := http.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHTTPConnectionHandler(t *testing.T) {
+	handler := fakeEventHandler{commands: make(chan slack.SlashCommand, 1)}
+	conn := newHTTPConnection(testSigningSecret, eventRouter{
+		handler: handler,
+		logger:  nopLogger{},
+		events:  repository.NewInMemoryEventRepository(),
+	}, nopLogger{})
+
+	// Runを呼ばなくても、作成した時点でリクエストを受け付けられる
+	server := httptest.NewServer(conn.Handler())
+	defer server.Close()
+
+	command := url.Values{"command": {"/gpt"}, "text": {"help"}, "user_id": {"U0001"}}.Encode()
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		secret     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "url verification",
+			path:       EventsPath,
+			body:       `{"type":"url_verification","challenge":"abc123"}`,
+			secret:     testSigningSecret,
+			wantStatus: http.StatusOK,
+			wantBody:   "abc123",
+		},
+		{
+			name:       "invalid signature",
+			path:       EventsPath,
+			body:       `{"type":"url_verification","challenge":"abc123"}`,
+			secret:     "wrong-secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "slash command",
+			path:       CommandsPath,
+			body:       command,
+			secret:     testSigningSecret,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unknown path",
+			path:       "/slack/unknown",
+			body:       "{}",
+			secret:     testSigningSecret,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType := "application/json"
+			if tt.path == CommandsPath {
+				contentType = "application/x-www-form-urlencoded"
+			}
+
+			resp, err := http.DefaultClient.Do(signedRequest(t, server.URL+tt.path, contentType, tt.body, tt.secret))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	select {
+	case cmd := <-handler.commands:
+		if cmd.Command != "/gpt" || cmd.Text != "help" || cmd.UserID != "U0001" {
+			t.Errorf("command = %+v", cmd)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("slash command was not routed")
+	}
+}
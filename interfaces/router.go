@@ -0,0 +1,84 @@
+package interfaces
+
+import (
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// eventRouter - 受け取ったイベントをEventHandlerに振り分けます (Socket ModeとHTTPで共通)
+type eventRouter struct {
+	handler EventHandler
+	logger  logger.Logger
+	events  repository.EventRepository
+}
+
+// routeEventsAPI - Events APIのイベントを処理します
+func (r eventRouter) routeEventsAPI(eventsAPIEvent slackevents.EventsAPIEvent) {
+	// Ackが遅れた場合や再接続時に、同じイベントが再送されることがある
+	if callback, ok := eventsAPIEvent.Data.(*slackevents.EventsAPICallbackEvent); ok && callback.EventID != "" {
+		if !r.events.MarkProcessed("event:" + callback.EventID) {
+			r.logger.Log(logger.VERB, "skip redelivered event: %s", callback.EventID)
+			return
+		}
+	}
+
+	switch event := eventsAPIEvent.InnerEvent.Data.(type) {
+	case *slackevents.AppMentionEvent:
+		r.logger.Log(logger.VERB, "app mention from user %s received", event.User)
+		err := r.handler.HandleAppMentionEvent(*event)
+		if err != nil {
+			r.logger.Log(logger.ERROR, "failed to handle app mention event: %v", err)
+		}
+	case *slackevents.MessageEvent:
+		r.logger.Log(logger.VERB, "message from user %s received", event.User)
+		err := r.handler.HandleMessageEvent(*event)
+		if err != nil {
+			r.logger.Log(logger.ERROR, "failed to handle app mention event: %v", err)
+		}
+	case *slackevents.AppHomeOpenedEvent:
+		r.logger.Log(logger.VERB, "app home opened by user %s", event.User)
+		err := r.handler.HandleAppHomeOpened(*event)
+		if err != nil {
+			r.logger.Log(logger.ERROR, "failed to handle app home opened event: %v", err)
+		}
+	default:
+		r.logger.Log(logger.VERB, "unexpected event type received: %s", eventsAPIEvent.InnerEvent.Type)
+	}
+}
+
+// routeInteraction - ボタンやショートカット、モーダル等の操作を処理します
+func (r eventRouter) routeInteraction(event slack.InteractionCallback) {
+	switch event.Type {
+	case slack.InteractionTypeBlockActions:
+		r.logger.Log(logger.VERB, "block action from user %s received", event.User.ID)
+		err := r.handler.HandleBlockActionsEvent(event)
+		if err != nil {
+			r.logger.Log(logger.ERROR, "failed to handle block action event: %v", err)
+		}
+	case slack.InteractionTypeMessageAction:
+		r.logger.Log(logger.VERB, "message shortcut from user %s received", event.User.ID)
+		err := r.handler.HandleMessageShortcut(event)
+		if err != nil {
+			r.logger.Log(logger.ERROR, "failed to handle message shortcut: %v", err)
+		}
+	case slack.InteractionTypeViewSubmission:
+		r.logger.Log(logger.VERB, "view submission from user %s received", event.User.ID)
+		err := r.handler.HandleViewSubmission(event)
+		if err != nil {
+			r.logger.Log(logger.ERROR, "failed to handle view submission: %v", err)
+		}
+	default:
+		r.logger.Log(logger.VERB, "unexpected interaction type received: %s", event.Type)
+	}
+}
+
+// routeSlashCommand - スラッシュコマンドを処理します
+func (r eventRouter) routeSlashCommand(cmd slack.SlashCommand) {
+	r.logger.Log(logger.VERB, "slash command %s from user %s received", cmd.Command, cmd.UserID)
+	err := r.handler.HandleSlashCommand(cmd)
+	if err != nil {
+		r.logger.Log(logger.ERROR, "failed to handle slash command: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package interfaces
+
+import (
+	"errors"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/usecase"
+	"github.com/slack-go/slack"
+	"strings"
+)
+
+const (
+	OnAskModelNotAllowedMessage = "選択したモデルは利用できません。別のモデルを選択して、もう一度お試しください。"
+
+	OnAskCannotPostMessage = "質問を投稿できませんでした。ボットをこのチャンネルに招待してから (またはボットにchat:writeの権限があるか確認してから)、もう一度お試しください。"
+
+	OnAskErrorMessage = "質問を受け付けられませんでした。しばらく時間をおいてから、もう一度お試しください。"
+
+	OnSettingsModelNotAllowedMessage = "個人設定を保存できませんでした。選択したモデルは利用できません。"
+
+	OnSettingsInvalidMessage = "個人設定を保存できませんでした。入力内容を確認して、もう一度お試しください。"
+
+	OnSettingsErrorMessage = "個人設定を保存できませんでした。しばらく時間をおいてから、もう一度お試しください。"
+)
+
+// HandleMessageShortcut - メッセージショートカットを受け取り、質問を入力するモーダルを開きます
+func (e eventHandler) HandleMessageShortcut(event slack.InteractionCallback) error {
+	if event.CallbackID != slackapi.AskShortcutCallbackID {
+		e.logger.Log(logger.VERB, "unexpected message shortcut received: %s", event.CallbackID)
+		return nil
+	}
+
+	// 個人設定で既定のモデルを選んでいれば、それを選択した状態で開く
+	model := e.settings.Model(event.User.ID)
+	if model == "" {
+		model = e.config.OpenAIModel()
+	}
+
+	e.logger.Log(logger.INFO, "open ask modal userid: %s", event.User.ID)
+	return e.api.OpenAskModal(event.TriggerID, slackapi.AskModalMetadata{
+		ChannelID: event.Channel.ID,
+		MessageTS: event.Message.Timestamp,
+		ThreadTS:  event.Message.ThreadTimestamp,
+	}, e.config.AllowedModels(), model)
+}
+
+// HandleViewSubmission - モーダルの送信を受け取り、モーダルの種類に応じて処理します
+// モーダルは送信と同時に閉じているため、失敗した場合はユーザーにだけ見えるメッセージで知らせます
+func (e eventHandler) HandleViewSubmission(event slack.InteractionCallback) error {
+	switch event.View.CallbackID {
+	case slackapi.AskModalCallbackID:
+		err := e.askAboutMessage(event)
+		if err != nil {
+			submission, _ := slackapi.ParseAskModalSubmission(event.View)
+			e.notifyUser(submission.ChannelID, event.User.ID, askErrorMessage(err))
+		}
+		return err
+	case slackapi.SettingsModalCallbackID:
+		err := e.saveSettings(event)
+		if err != nil {
+			e.notifyUser("", event.User.ID, settingsErrorMessage(err))
+		}
+		return err
+	default:
+		e.logger.Log(logger.VERB, "unexpected view submission received: %s", event.View.CallbackID)
+		return nil
+	}
+}
+
+// askAboutMessage - 質問のモーダルの送信内容から、対象のメッセージについての会話を開始します
+func (e eventHandler) askAboutMessage(event slack.InteractionCallback) error {
+	submission, err := slackapi.ParseAskModalSubmission(event.View)
+	if err != nil {
+		return err
+	}
+	prompt := strings.TrimSpace(submission.Prompt)
+	if prompt == "" {
+		return nil
+	}
+
+	e.logger.Log(logger.INFO, "start conversation about message userid: %s, model: %s", event.User.ID, submission.Model)
+	go e.stat.UsedBy(event.User.ID)
+	return e.chat.AskAboutMessage(
+		submission.ChannelID,
+		submission.MessageTS,
+		submission.ThreadTS,
+		event.User.ID,
+		prompt,
+		submission.Model,
+		submission.IncludeThread,
+	)
+}
+
+// notifyUser - ユーザーにだけ見えるメッセージをチャンネルに投稿します
+// チャンネルに投稿できない場合やチャンネルがない場合は、ボットとのDMに送信します
+func (e eventHandler) notifyUser(channelID string, userID string, msg string) {
+	if channelID != "" {
+		err := e.api.PostEphemeral(channelID, userID, msg)
+		if err == nil {
+			return
+		}
+		e.logger.Log(logger.WARN, "failed to notify user in channel: %v", err)
+	}
+
+	err := e.api.PostMessage(userID, "", msg)
+	if err != nil {
+		e.logger.Log(logger.ERROR, "failed to notify user: %v", err)
+	}
+}
+
+func askErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, usecase.ErrModelNotAllowed):
+		return OnAskModelNotAllowedMessage
+	case errors.Is(err, usecase.ErrCannotPost):
+		return OnAskCannotPostMessage
+	default:
+		return OnAskErrorMessage
+	}
+}
+
+func settingsErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, usecase.ErrModelNotAllowed):
+		return OnSettingsModelNotAllowedMessage
+	case errors.Is(err, usecase.ErrInvalidSettings), errors.Is(err, usecase.ErrInstructionsTooLong):
+		return OnSettingsInvalidMessage
+	default:
+		return OnSettingsErrorMessage
+	}
+}
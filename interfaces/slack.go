@@ -3,20 +3,26 @@ package interfaces
 import (
 	"github.com/SGE-AI/sge-bot/config"
 	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
+	"net/http"
 )
 
 type (
+	// SocketConnection - Slackからイベントを受け取る接続 (Socket ModeまたはHTTP)
 	SocketConnection interface {
 		Run() error
+
+		// Handler - HTTPでイベントを受け取る場合のハンドラー。Socket Modeの場合はnilを返します
+		Handler() http.Handler
 	}
 
 	socketConnection struct {
-		webApi  *slack.Client
-		handler EventHandler
-		logger  logger.Logger
+		webApi *slack.Client
+		router eventRouter
+		logger logger.Logger
 	}
 )
 
@@ -32,6 +38,9 @@ func (s socketConnection) Run() error {
 			case socketmode.EventTypeInteractive:
 				s.logger.Log(logger.VERB, "interactive event received")
 				go s.handleInteractiveEvent(socketMode, envelope)
+			case socketmode.EventTypeSlashCommand:
+				s.logger.Log(logger.VERB, "slash command event received")
+				go s.handleSlashCommandEvent(socketMode, envelope)
 			case socketmode.EventTypeConnecting:
 				s.logger.Log(logger.VERB, "connecting to slack...")
 			case socketmode.EventTypeConnectionError:
@@ -49,6 +58,11 @@ func (s socketConnection) Run() error {
 	return socketMode.Run()
 }
 
+// Handler - Socket ModeではHTTPのリクエストを受け付けないため、nilを返します
+func (s socketConnection) Handler() http.Handler {
+	return nil
+}
+
 // handleEventTypeEventsAPI - EventTypeEventsAPIを処理します
 func (s socketConnection) handleEventTypeEventsAPI(client *socketmode.Client, envelope socketmode.Event) {
 	eventsAPIEvent, ok := envelope.Data.(slackevents.EventsAPIEvent)
@@ -58,23 +72,7 @@ func (s socketConnection) handleEventTypeEventsAPI(client *socketmode.Client, en
 	}
 
 	client.Ack(*envelope.Request)
-
-	switch event := eventsAPIEvent.InnerEvent.Data.(type) {
-	case *slackevents.AppMentionEvent:
-		s.logger.Log(logger.VERB, "app mention from user %s received", event.User)
-		err := s.handler.HandleAppMentionEvent(*event)
-		if err != nil {
-			s.logger.Log(logger.ERROR, "failed to handle app mention event: %v", err)
-		}
-	case *slackevents.MessageEvent:
-		s.logger.Log(logger.VERB, "message from user %s received", event.User)
-		err := s.handler.HandleMessageEvent(*event)
-		if err != nil {
-			s.logger.Log(logger.ERROR, "failed to handle app mention event: %v", err)
-		}
-	default:
-		s.logger.Log(logger.VERB, "unexpected event type received: %s", envelope.Type)
-	}
+	s.router.routeEventsAPI(eventsAPIEvent)
 }
 
 // handleInteractiveEvent - EventTypeInteractiveを処理します
@@ -84,28 +82,41 @@ func (s socketConnection) handleInteractiveEvent(client *socketmode.Client, enve
 		s.logger.Log(logger.VERB, "unexpected event type received: %s", envelope.Type)
 		return
 	}
+
 	client.Ack(*envelope.Request)
+	s.router.routeInteraction(event)
+}
 
-	switch event.Type {
-	case slack.InteractionTypeBlockActions:
-		s.logger.Log(logger.VERB, "block action from user %s received", event.User.ID)
-		err := s.handler.HandleBlockActionsEvent(event)
-		if err != nil {
-			s.logger.Log(logger.ERROR, "failed to handle block action event: %v", err)
-		}
-	default:
+// handleSlashCommandEvent - EventTypeSlashCommandを処理します
+func (s socketConnection) handleSlashCommandEvent(client *socketmode.Client, envelope socketmode.Event) {
+	cmd, ok := envelope.Data.(slack.SlashCommand)
+	if !ok {
 		s.logger.Log(logger.VERB, "unexpected event type received: %s", envelope.Type)
+		return
 	}
+
+	client.Ack(*envelope.Request)
+	s.router.routeSlashCommand(cmd)
 }
 
-func ProvideSocketConnection(config config.Config, handler EventHandler, logger logger.Logger) SocketConnection {
-	botToken := config.SlackBotToken()
-	appLevelToken := config.SlackAppLevelToken()
+func ProvideSocketConnection(cfg config.Config, handler EventHandler, logger logger.Logger, events repository.EventRepository) SocketConnection {
+	router := eventRouter{
+		handler: handler,
+		logger:  logger,
+		events:  events,
+	}
+
+	if cfg.SlackTransport() == config.SlackTransportHTTP {
+		return newHTTPConnection(cfg.SlackSigningSecret(), router, logger)
+	}
+
+	botToken := cfg.SlackBotToken()
+	appLevelToken := cfg.SlackAppLevelToken()
 	webApi := slack.New(botToken, slack.OptionAppLevelToken(appLevelToken))
 
 	return &socketConnection{
-		webApi:  webApi,
-		handler: handler,
-		logger:  logger,
+		webApi: webApi,
+		router: router,
+		logger: logger,
 	}
 }
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"github.com/SGE-AI/sge-bot/config"
+	"github.com/SGE-AI/sge-bot/gpt"
 	"github.com/SGE-AI/sge-bot/interfaces"
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/SGE-AI/sge-bot/slackapi"
@@ -15,6 +17,7 @@ type Application struct {
 	logger logger.Logger
 	socket interfaces.SocketConnection
 	slack  slackapi.SlackAPI
+	gpt    gpt.Client
 }
 
 func ProvideApplication(
@@ -22,20 +25,29 @@ func ProvideApplication(
 	logger logger.Logger,
 	socket interfaces.SocketConnection,
 	slack slackapi.SlackAPI,
+	gpt gpt.Client,
 ) *Application {
 	return &Application{
 		config: config,
 		logger: logger,
 		socket: socket,
 		slack:  slack,
+		gpt:    gpt,
 	}
 }
 
-func handleRequests(port string) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+// handleRequests - ヘルスチェックと、HTTPでイベントを受け取る場合はSlackからのリクエストを処理します
+// リクエストを取りこぼさないよう、すべてのハンドラーを登録してから待ち受けを開始します
+func handleRequests(port string, slackHandler http.Handler) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte("OK"))
 	})
-	err := http.ListenAndServe(port, nil)
+	if slackHandler != nil {
+		mux.Handle("/slack/", slackHandler)
+	}
+
+	err := http.ListenAndServe(port, mux)
 	if err != nil {
 		panic(err)
 	}
@@ -49,22 +61,28 @@ func main() {
 
 	botUser, err := app.slack.GetBotUserId()
 	if err != nil {
-		app.logger.Log(logger.ERROR, err.Error())
+		app.logger.Log(logger.ERROR, "%v", err)
 		os.Exit(1)
 	}
 	app.config.SetBotUserID(botUser)
 	app.logger.Log(logger.INFO, "bot user id: %s", app.config.BotUserID())
 
+	err = app.gpt.VerifyModel(context.Background())
+	if err != nil {
+		app.logger.Log(logger.ERROR, "%v", err)
+		os.Exit(1)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
 	app.logger.Log(logger.INFO, "http listening on port %s", port)
-	go handleRequests(":" + port)
+	go handleRequests(":"+port, app.socket.Handler())
 
 	err = app.socket.Run()
 	if err != nil {
-		app.logger.Log(logger.ERROR, err.Error())
+		app.logger.Log(logger.ERROR, "%v", err)
 	}
 }
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// EventTTL - 処理済みのイベントを覚えておく期間 (Slackの再送はこの期間内に行われる)
+	EventTTL = 10 * time.Minute
+)
+
+type (
+	// EventRepository - 処理済みのイベントを記録し、同じイベントを二度処理しないようにするリポジトリ
+	EventRepository interface {
+		// MarkProcessed - keyを処理済みとして記録します。既に記録されていた場合はfalseを返します
+		MarkProcessed(key string) bool
+	}
+
+	inMemoryEvent struct {
+		mu   sync.Mutex
+		seen map[string]time.Time
+	}
+)
+
+func (i *inMemoryEvent) MarkProcessed(key string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	for k, seenAt := range i.seen {
+		if now.Sub(seenAt) > EventTTL {
+			delete(i.seen, k)
+		}
+	}
+
+	if _, ok := i.seen[key]; ok {
+		return false
+	}
+	i.seen[key] = now
+	return true
+}
+
+func NewInMemoryEventRepository() EventRepository {
+	return &inMemoryEvent{
+		seen: make(map[string]time.Time),
+	}
+}
+
+var eventSingleton EventRepository
+
+func ProvideEventRepository() EventRepository {
+	if eventSingleton == nil {
+		eventSingleton = NewInMemoryEventRepository()
+	}
+	return eventSingleton
+}
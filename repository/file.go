@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// jsonFile - 値をJSONとして保存するファイル
+// 書き込み途中で停止しても壊れたファイルが残らないよう、一時ファイルに書き込んでから置き換えます
+type jsonFile struct {
+	path string
+}
+
+// load - ファイルの内容をvに読み込みます。ファイルがまだない場合は何もしません
+func (f jsonFile) load(v interface{}) error {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", f.path, err)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", f.path, err)
+	}
+	return nil
+}
+
+func (f jsonFile) save(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", f.path, err)
+	}
+
+	dir := filepath.Dir(f.path)
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file in %s: %v", dir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp.Name(), err)
+	}
+
+	err = os.Rename(tmp.Name(), f.path)
+	if err != nil {
+		return fmt.Errorf("failed to replace %s: %v", f.path, err)
+	}
+	return nil
+}
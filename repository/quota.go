@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/SGE-AI/sge-bot/config"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// QuotaUsage - 集計期間内の使用量
+	QuotaUsage struct {
+		Tokens int
+		Cost   float64
+	}
+
+	// QuotaGrant - 管理者による利用上限の一時的な引き上げ
+	QuotaGrant struct {
+		// Metric - 引き上げる対象 (tokens または usd)
+		Metric string
+
+		Amount    float64
+		GrantedBy string
+		ExpiresAt time.Time
+	}
+
+	// QuotaRepository - 利用上限のための使用量の集計と、上限の引き上げを保存するリポジトリ
+	QuotaRepository interface {
+		// AddUsage - keyの使用量を加算します。expiresAtを過ぎた集計は破棄されます
+		AddUsage(key string, expiresAt time.Time, usage QuotaUsage) error
+		Usage(key string) QuotaUsage
+
+		AddGrant(userID string, grant QuotaGrant) error
+
+		// Grants - ユーザーの有効な (期限切れでない) 引き上げを取得します
+		Grants(userID string) []QuotaGrant
+	}
+
+	quotaCounter struct {
+		Usage     QuotaUsage
+		ExpiresAt time.Time
+	}
+
+	// quotaState - 使用量の集計と上限の引き上げ (ファイルに保存する内容)
+	quotaState struct {
+		Counters map[string]quotaCounter
+		Grants   map[string][]QuotaGrant
+	}
+
+	quotaRepository struct {
+		mu    sync.Mutex
+		state quotaState
+
+		// file - 保存先。nilの場合はメモリ上にのみ保持します
+		file *jsonFile
+	}
+)
+
+func (r *quotaRepository) AddUsage(key string, expiresAt time.Time, usage QuotaUsage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, c := range r.state.Counters {
+		if now.After(c.ExpiresAt) {
+			delete(r.state.Counters, k)
+		}
+	}
+
+	counter := r.state.Counters[key]
+	counter.Usage.Tokens += usage.Tokens
+	counter.Usage.Cost += usage.Cost
+	counter.ExpiresAt = expiresAt
+	r.state.Counters[key] = counter
+	return r.save()
+}
+
+func (r *quotaRepository) Usage(key string) QuotaUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counter, ok := r.state.Counters[key]
+	if !ok || time.Now().After(counter.ExpiresAt) {
+		return QuotaUsage{}
+	}
+	return counter.Usage
+}
+
+func (r *quotaRepository) AddGrant(userID string, grant QuotaGrant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state.Grants[userID] = append(r.activeGrants(userID), grant)
+	return r.save()
+}
+
+func (r *quotaRepository) Grants(userID string) []QuotaGrant {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.activeGrants(userID)
+}
+
+func (r *quotaRepository) activeGrants(userID string) []QuotaGrant {
+	var grants []QuotaGrant
+	now := time.Now()
+	for _, grant := range r.state.Grants[userID] {
+		if now.Before(grant.ExpiresAt) {
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+func (r *quotaRepository) save() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.save(r.state)
+}
+
+func NewInMemoryQuotaRepository() QuotaRepository {
+	return &quotaRepository{
+		state: quotaState{
+			Counters: make(map[string]quotaCounter),
+			Grants:   make(map[string][]QuotaGrant),
+		},
+	}
+}
+
+// NewFileQuotaRepository - 集計をファイルに保存するリポジトリを作成します。ファイルがあれば保存済みの集計を読み込みます
+func NewFileQuotaRepository(path string) (QuotaRepository, error) {
+	file := &jsonFile{path: path}
+	state := quotaState{}
+	err := file.load(&state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quota: %v", err)
+	}
+
+	if state.Counters == nil {
+		state.Counters = make(map[string]quotaCounter)
+	}
+	if state.Grants == nil {
+		state.Grants = make(map[string][]QuotaGrant)
+	}
+	return &quotaRepository{state: state, file: file}, nil
+}
+
+var quotaSingleton QuotaRepository
+
+// ProvideQuotaRepository - DATA_DIRが設定されていればファイルに、なければメモリ上に集計を保存するリポジトリを返します
+func ProvideQuotaRepository(cfg config.Config) QuotaRepository {
+	if quotaSingleton != nil {
+		return quotaSingleton
+	}
+
+	if cfg.DataDir() == "" {
+		quotaSingleton = NewInMemoryQuotaRepository()
+		return quotaSingleton
+	}
+
+	repo, err := NewFileQuotaRepository(filepath.Join(cfg.DataDir(), "quota.json"))
+	if err != nil {
+		panic(err)
+	}
+	quotaSingleton = repo
+	return quotaSingleton
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileQuotaRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "quota.json")
+
+	repo, err := NewFileQuotaRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileQuotaRepository() error = %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	for _, usage := range []QuotaUsage{{Tokens: 100, Cost: 0.5}, {Tokens: 20, Cost: 0.25}} {
+		err = repo.AddUsage("user:U1:2026-10-18", expiresAt, usage)
+		if err != nil {
+			t.Fatalf("AddUsage() error = %v", err)
+		}
+	}
+	err = repo.AddUsage("user:U2:2026-10-17", time.Now().Add(-time.Hour), QuotaUsage{Tokens: 1})
+	if err != nil {
+		t.Fatalf("AddUsage() error = %v", err)
+	}
+	err = repo.AddGrant("U1", QuotaGrant{Metric: "tokens", Amount: 1000, GrantedBy: "UADMIN", ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("AddGrant() error = %v", err)
+	}
+	err = repo.AddGrant("U2", QuotaGrant{Metric: "usd", Amount: 1, GrantedBy: "UADMIN", ExpiresAt: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("AddGrant() error = %v", err)
+	}
+
+	// 再起動後に読み込み直しても、集計と引き上げが残っている
+	reloaded, err := NewFileQuotaRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileQuotaRepository() error = %v", err)
+	}
+
+	if got := reloaded.Usage("user:U1:2026-10-18"); got != (QuotaUsage{Tokens: 120, Cost: 0.75}) {
+		t.Errorf("Usage() = %v", got)
+	}
+	if got := reloaded.Usage("user:U2:2026-10-17"); got != (QuotaUsage{}) {
+		t.Errorf("expired Usage() = %v", got)
+	}
+	if got := reloaded.Grants("U1"); len(got) != 1 || got[0].Amount != 1000 || !got[0].ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Grants() = %v", got)
+	}
+	if got := reloaded.Grants("U2"); len(got) != 0 {
+		t.Errorf("expired Grants() = %v", got)
+	}
+}
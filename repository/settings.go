@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/SGE-AI/sge-bot/config"
+	"path/filepath"
+	"sync"
+)
+
+type (
+	// UserSettings - ユーザーごとの個人設定
+	UserSettings struct {
+		// Model - 既定で利用するモデル。空の場合はボットの設定に従います
+		Model string
+
+		// Language - 回答に使う言語 (ja, en 等)。空の場合は質問と同じ言語で回答します
+		Language string
+
+		// AnswerStyle - 回答のスタイル (concise, detailed 等)。空の場合は指定しません
+		AnswerStyle string
+
+		// Temperature - 回答のランダム性。nilの場合はモデルの既定値を利用します
+		Temperature *float32
+
+		// Instructions - 回答の際に考慮させる個人的な指示
+		Instructions string
+	}
+
+	// UserSettingsRepository - ユーザーごとの個人設定を保存するリポジトリ
+	UserSettingsRepository interface {
+		// Load - ユーザーの設定を取得します。保存されていない場合は空の設定を返します
+		Load(userID string) UserSettings
+		Save(userID string, settings UserSettings) error
+	}
+
+	userSettingsRepository struct {
+		mu    sync.Mutex
+		store map[string]UserSettings
+
+		// file - 保存先。nilの場合はメモリ上にのみ保持します
+		file *jsonFile
+	}
+)
+
+func (r *userSettingsRepository) Load(userID string) UserSettings {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.store[userID]
+}
+
+func (r *userSettingsRepository) Save(userID string, settings UserSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous, existed := r.store[userID]
+	r.store[userID] = settings
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.save(r.store)
+	if err != nil {
+		// 保存できなかった設定が再起動まで有効にならないよう、元に戻す
+		if existed {
+			r.store[userID] = previous
+		} else {
+			delete(r.store, userID)
+		}
+		return err
+	}
+	return nil
+}
+
+func NewInMemoryUserSettingsRepository() UserSettingsRepository {
+	return &userSettingsRepository{
+		store: make(map[string]UserSettings),
+	}
+}
+
+// NewFileUserSettingsRepository - 個人設定をファイルに保存するリポジトリを作成します。ファイルがあれば保存済みの設定を読み込みます
+func NewFileUserSettingsRepository(path string) (UserSettingsRepository, error) {
+	file := &jsonFile{path: path}
+	store := make(map[string]UserSettings)
+	err := file.load(&store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user settings: %v", err)
+	}
+
+	return &userSettingsRepository{store: store, file: file}, nil
+}
+
+var userSettingsSingleton UserSettingsRepository
+
+// ProvideUserSettingsRepository - DATA_DIRが設定されていればファイルに、なければメモリ上に個人設定を保存するリポジトリを返します
+func ProvideUserSettingsRepository(cfg config.Config) UserSettingsRepository {
+	if userSettingsSingleton != nil {
+		return userSettingsSingleton
+	}
+
+	if cfg.DataDir() == "" {
+		userSettingsSingleton = NewInMemoryUserSettingsRepository()
+		return userSettingsSingleton
+	}
+
+	repo, err := NewFileUserSettingsRepository(filepath.Join(cfg.DataDir(), "settings.json"))
+	if err != nil {
+		panic(err)
+	}
+	userSettingsSingleton = repo
+	return userSettingsSingleton
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileUserSettingsRepository(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+
+	repo, err := NewFileUserSettingsRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileUserSettingsRepository() error = %v", err)
+	}
+	if got := repo.Load("U1"); !reflect.DeepEqual(got, UserSettings{}) {
+		t.Errorf("Load() before save = %+v", got)
+	}
+
+	temperature := float32(0.3)
+	settings := UserSettings{
+		Model:        "gpt-4o",
+		Language:     "ja",
+		AnswerStyle:  "concise",
+		Temperature:  &temperature,
+		Instructions: "箇条書きで答えてください",
+	}
+	err = repo.Save("U1", settings)
+	if err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// 再起動後に読み込み直しても、個人設定が残っている
+	reloaded, err := NewFileUserSettingsRepository(path)
+	if err != nil {
+		t.Fatalf("NewFileUserSettingsRepository() error = %v", err)
+	}
+	if got := reloaded.Load("U1"); !reflect.DeepEqual(got, settings) {
+		t.Errorf("Load() = %+v, want %+v", got, settings)
+	}
+}
+
+func TestFileUserSettingsRepositorySaveError(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileUserSettingsRepository(filepath.Join(dir, "settings.json"))
+	if err != nil {
+		t.Fatalf("NewFileUserSettingsRepository() error = %v", err)
+	}
+
+	// 保存先のディレクトリをファイルで塞いで、書き込めないようにする
+	blocked := filepath.Join(dir, "blocked")
+	err = os.WriteFile(blocked, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.(*userSettingsRepository).file.path = filepath.Join(blocked, "settings.json")
+
+	err = repo.Save("U1", UserSettings{Model: "gpt-4o"})
+	if err == nil {
+		t.Fatal("Save() error = nil")
+	}
+	if got := repo.Load("U1"); got.Model != "" {
+		t.Errorf("Load() after failed save = %+v", got)
+	}
+}
+
+func TestNewFileUserSettingsRepositoryBroken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	err := os.WriteFile(path, []byte("{"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewFileUserSettingsRepository(path)
+	if err == nil {
+		t.Fatal("NewFileUserSettingsRepository() error = nil")
+	}
+}
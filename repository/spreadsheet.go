@@ -16,6 +16,12 @@ type (
 		SlackUserID string
 		UseCount    int
 		LastUsed    string
+		LastModel   string
+
+		// PromptTokens, CompletionTokens, Cost - これまでの回答で使用したトークン数と料金 (USD) の累計
+		PromptTokens     int
+		CompletionTokens int
+		Cost             float64
 	}
 
 	SpreadsheetRepository interface {
@@ -30,7 +36,7 @@ type (
 )
 
 func (s *spreadsheetRepository) Get(SlackUserID string) (UserStatistics, error) {
-	readRange := fmt.Sprintf("A:C")
+	readRange := fmt.Sprintf("A:G")
 	resp, err := s.service.Spreadsheets.Values.Get(s.spreadSheetID, readRange).Do()
 
 	if err != nil {
@@ -47,10 +53,27 @@ func (s *spreadsheetRepository) Get(SlackUserID string) (UserStatistics, error)
 				useCount, _ := strconv.Atoi(row[1].(string))
 				lastUsed, _ := row[2].(string)
 
+				var lastModel string
+				if len(row) >= 4 {
+					lastModel, _ = row[3].(string)
+				}
+
+				var promptTokens, completionTokens int
+				var cost float64
+				if len(row) >= 7 {
+					promptTokens, _ = strconv.Atoi(fmt.Sprint(row[4]))
+					completionTokens, _ = strconv.Atoi(fmt.Sprint(row[5]))
+					cost, _ = strconv.ParseFloat(fmt.Sprint(row[6]), 64)
+				}
+
 				return UserStatistics{
-					SlackUserID: userId,
-					UseCount:    useCount,
-					LastUsed:    lastUsed,
+					SlackUserID:      userId,
+					UseCount:         useCount,
+					LastUsed:         lastUsed,
+					LastModel:        lastModel,
+					PromptTokens:     promptTokens,
+					CompletionTokens: completionTokens,
+					Cost:             cost,
 				}, nil
 			}
 		}
@@ -64,7 +87,7 @@ func (s *spreadsheetRepository) Get(SlackUserID string) (UserStatistics, error)
 }
 
 func (s *spreadsheetRepository) Update(stat UserStatistics) error {
-	readRange := fmt.Sprintf("A:C")
+	readRange := fmt.Sprintf("A:G")
 	resp, err := s.service.Spreadsheets.Values.Get(s.spreadSheetID, readRange).Do()
 	if err != nil {
 		return fmt.Errorf("unable to retrieve data from sheet: %v", err)
@@ -82,11 +105,11 @@ func (s *spreadsheetRepository) Update(stat UserStatistics) error {
 	}
 
 	valueRange := &sheets.ValueRange{
-		Values: [][]interface{}{{stat.SlackUserID, stat.UseCount, stat.LastUsed}},
+		Values: [][]interface{}{{stat.SlackUserID, stat.UseCount, stat.LastUsed, stat.LastModel, stat.PromptTokens, stat.CompletionTokens, stat.Cost}},
 	}
 
 	if rowToUpdate >= 0 {
-		updateRange := fmt.Sprintf("A%d:C%d", rowToUpdate+1, rowToUpdate+1)
+		updateRange := fmt.Sprintf("A%d:G%d", rowToUpdate+1, rowToUpdate+1)
 		_, err = s.service.Spreadsheets.Values.Update(s.spreadSheetID, updateRange, valueRange).ValueInputOption("RAW").Do()
 	} else {
 		_, err = s.service.Spreadsheets.Values.Append(s.spreadSheetID, readRange, valueRange).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Do()
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// SummaryTTL - 要約を保持する期間
+	SummaryTTL = 7 * 24 * time.Hour
+)
+
+type (
+	// Summary - スレッドの古い会話の要約
+	Summary struct {
+		// UntilTimeStamp - 要約に含まれている最後のメッセージのタイムスタンプ
+		UntilTimeStamp string
+
+		Text string
+
+		savedAt time.Time
+	}
+
+	// SummaryRepository - スレッドごとに会話の要約を保存するリポジトリ
+	SummaryRepository interface {
+		Save(threadKey string, summary Summary)
+		Load(threadKey string) (Summary, bool)
+	}
+
+	inMemorySummary struct {
+		mu    sync.Mutex
+		store map[string]Summary
+	}
+)
+
+func (i *inMemorySummary) Save(threadKey string, summary Summary) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	for key, s := range i.store {
+		if now.Sub(s.savedAt) > SummaryTTL {
+			delete(i.store, key)
+		}
+	}
+
+	summary.savedAt = now
+	i.store[threadKey] = summary
+}
+
+func (i *inMemorySummary) Load(threadKey string) (Summary, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	summary, ok := i.store[threadKey]
+	return summary, ok
+}
+
+func NewInMemorySummaryRepository() SummaryRepository {
+	return &inMemorySummary{
+		store: make(map[string]Summary),
+	}
+}
+
+var summarySingleton SummaryRepository
+
+func ProvideSummaryRepository() SummaryRepository {
+	if summarySingleton == nil {
+		summarySingleton = NewInMemorySummaryRepository()
+	}
+	return summarySingleton
+}
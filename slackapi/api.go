@@ -1,8 +1,10 @@
 package slackapi
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/SGE-AI/sge-bot/config"
+	"github.com/SGE-AI/sge-bot/conversation"
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/slack-go/slack"
 	"strings"
@@ -10,6 +12,9 @@ import (
 
 const (
 	CustomInstructionsMarker = "SlackBot:"
+
+	// PromptMessageFormat - ユーザーの代わりに投稿する質問の表示形式
+	PromptMessageFormat = ":speech_balloon: <@%s> さんの質問\n%s"
 )
 
 type (
@@ -17,22 +22,37 @@ type (
 		GetBotUserId() (string, error)
 		LoadConversationReplies(channelId string, timeStamp string) ([]slack.Message, error)
 		CreateNewBotMessage(channelId string, timeStamp string, msg string) (BotMessage, error)
-		TakeOverBotMessage(channelId string, botMessageTS string, controllerTS string) (BotMessage, error)
+		TakeOverBotMessage(channelId string, threadTS string, botMessageTS string, controllerTS string, partTS []string) (BotMessage, error)
 		LoadCustomInstructions(channelId string) (string, error)
+		GetUserTimeZone(userId string) (string, error)
+		DownloadFile(file slack.File, maxBytes int) ([]byte, error)
+		PostMessage(channelId string, timeStamp string, msg string) error
+		PostPromptMessage(channelId string, timeStamp string, userId string, prompt string) (string, error)
+		RespondEphemeral(responseURL string, msg string) error
+		PostEphemeral(channelId string, userId string, msg string) error
+		OpenAskModal(triggerID string, metadata AskModalMetadata, models []string, defaultModel string) error
+		OpenSettingsModal(triggerID string, form SettingsForm) error
+		PublishHomeView(userID string, home HomeView) error
 	}
 
 	slackAPI struct {
 		client *slack.Client
 		logger logger.Logger
 	}
+
+	// limitedBuffer - limitバイトを超えて書き込もうとするとエラーを返すバッファ
+	limitedBuffer struct {
+		bytes.Buffer
+		limit int
+	}
 )
 
 func (s slackAPI) CreateNewBotMessage(channelId string, timeStamp string, msg string) (BotMessage, error) {
 	return NewBotMessage(s.client, channelId, timeStamp, msg)
 }
 
-func (s slackAPI) TakeOverBotMessage(channelId string, botMessageTS string, controllerTS string) (BotMessage, error) {
-	return TakeOverBotMessage(s.client, channelId, botMessageTS, controllerTS), nil
+func (s slackAPI) TakeOverBotMessage(channelId string, threadTS string, botMessageTS string, controllerTS string, partTS []string) (BotMessage, error) {
+	return TakeOverBotMessage(s.client, channelId, threadTS, botMessageTS, controllerTS, partTS), nil
 }
 
 func (s slackAPI) LoadConversationReplies(channelId string, timeStamp string) ([]slack.Message, error) {
@@ -42,9 +62,10 @@ func (s slackAPI) LoadConversationReplies(channelId string, timeStamp string) ([
 	var cursor string = ""
 	for {
 		resp, hasMore, nextCursor, err := s.client.GetConversationReplies(&slack.GetConversationRepliesParameters{
-			ChannelID: channelId,
-			Timestamp: timeStamp,
-			Cursor:    cursor,
+			ChannelID:          channelId,
+			Timestamp:          timeStamp,
+			Cursor:             cursor,
+			IncludeAllMetadata: true,
 		})
 
 		if err != nil {
@@ -91,6 +112,110 @@ func (s slackAPI) LoadCustomInstructions(channelId string) (string, error) {
 	return ci, nil
 }
 
+// GetUserTimeZone - ユーザーのタイムゾーン (例: Asia/Tokyo) を取得します
+func (s slackAPI) GetUserTimeZone(userId string) (string, error) {
+	user, err := s.client.GetUserInfo(userId)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user info: %v", err)
+	}
+
+	return user.TZ, nil
+}
+
+// DownloadFile - Slackにアップロードされたファイルをボットのトークンでダウンロードします
+// ファイルのサイズがmaxBytesを超える場合はダウンロードしません
+func (s slackAPI) DownloadFile(file slack.File, maxBytes int) ([]byte, error) {
+	if file.Size > maxBytes {
+		return nil, fmt.Errorf("file %s is too large: %d bytes", file.ID, file.Size)
+	}
+
+	url := file.URLPrivateDownload
+	if url == "" {
+		url = file.URLPrivate
+	}
+
+	// Slackが返したサイズが実際と異なる場合に備えて、受信中も上限を確認する
+	buf := &limitedBuffer{limit: maxBytes}
+	err := s.client.GetFile(url, buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file %s: %v", file.ID, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("file exceeds %d bytes", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// PostMessage - スレッドにテキストのメッセージを投稿します (生成を伴わない返信向け)
+func (s slackAPI) PostMessage(channelId string, timeStamp string, msg string) error {
+	_, _, err := s.client.PostMessage(channelId, slack.MsgOptionText(msg, false), slack.MsgOptionTS(timeStamp))
+	if err != nil {
+		return fmt.Errorf("failed to post message: %v", err)
+	}
+
+	return nil
+}
+
+// PostPromptMessage - ユーザーの代わりに質問をチャンネルに投稿し、そのタイムスタンプを返します
+// timeStampを指定した場合はそのスレッドに、空の場合はチャンネルに投稿します
+// 質問はメタデータとして埋め込まれ、スレッドを読み込む際にユーザーのメッセージとして扱われます
+func (s slackAPI) PostPromptMessage(channelId string, timeStamp string, userId string, prompt string) (string, error) {
+	text := fmt.Sprintf(PromptMessageFormat, userId, quote(prompt))
+	options := []slack.MsgOption{
+		slack.MsgOptionText(text, false),
+		slack.MsgOptionMetadata(slack.SlackMetadata{
+			EventType: conversation.PromptMetadataEventType,
+			EventPayload: map[string]interface{}{
+				"user_id": userId,
+				"prompt":  prompt,
+			},
+		}),
+	}
+	if timeStamp != "" {
+		options = append(options, slack.MsgOptionTS(timeStamp))
+	}
+
+	_, ts, err := s.client.PostMessage(channelId, options...)
+	if err != nil {
+		return "", fmt.Errorf("failed to post prompt message: %v", err)
+	}
+
+	return ts, nil
+}
+
+// RespondEphemeral - スラッシュコマンド等のresponse_urlに、実行したユーザーにだけ見えるメッセージを送信します
+func (s slackAPI) RespondEphemeral(responseURL string, msg string) error {
+	err := slack.PostWebhook(responseURL, &slack.WebhookMessage{
+		Text:         msg,
+		ResponseType: slack.ResponseTypeEphemeral,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to respond ephemeral message: %v", err)
+	}
+
+	return nil
+}
+
+// PostEphemeral - チャンネルに、指定したユーザーにだけ見えるメッセージを投稿します
+func (s slackAPI) PostEphemeral(channelId string, userId string, msg string) error {
+	_, err := s.client.PostEphemeral(channelId, userId, slack.MsgOptionText(msg, false))
+	if err != nil {
+		return fmt.Errorf("failed to post ephemeral message: %v", err)
+	}
+
+	return nil
+}
+
+// quote - 複数行の文章を引用の形式にします
+func quote(text string) string {
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
 func (s slackAPI) parseCustomInstructions(input string) string {
 	lines := strings.Split(input, "\n")
 
@@ -0,0 +1,80 @@
+package slackapi
+
+import (
+	"fmt"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// EditSettingsActionID - Homeタブの「設定を変更」ボタンのAction ID
+	EditSettingsActionID = "edit_settings"
+
+	// EditInstructionsActionID - Homeタブの「指示を編集」ボタンのAction ID
+	EditInstructionsActionID = "edit_instructions"
+
+	// HomeHelpText - Homeタブに表示する使い方
+	HomeHelpText = "*使い方*\n" +
+		"• チャンネルでボットにメンションすると、そのスレッドで回答します。スレッド内で続けてメンションすると、それまでの会話を踏まえて回答します\n" +
+		"• ボットにDMを送ると、メンションなしで会話できます\n" +
+		"• チャンネルのトピックや説明に `" + CustomInstructionsMarker + "` に続けて指示を書くと、そのチャンネルでの回答に反映されます\n" +
+		"• `/gpt help` でスラッシュコマンドの使い方を確認できます"
+)
+
+// HomeView - Homeタブに表示する内容
+type HomeView struct {
+	// Usage - 利用状況 (mrkdwn)
+	Usage string
+
+	// Settings - 個人設定の一覧 (mrkdwn)
+	Settings string
+
+	// Instructions - 個人的な指示。空の場合は未設定と表示します
+	Instructions string
+}
+
+// PublishHomeView - ユーザーのHomeタブを更新します
+func (s slackAPI) PublishHomeView(userID string, home HomeView) error {
+	_, err := s.client.PublishView(userID, buildHomeView(home), "")
+	if err != nil {
+		return fmt.Errorf("failed to publish home view: %v", err)
+	}
+
+	return nil
+}
+
+func buildHomeView(home HomeView) slack.HomeTabViewRequest {
+	instructions := "_未設定_"
+	if home.Instructions != "" {
+		instructions = quote(home.Instructions)
+	}
+
+	return slack.HomeTabViewRequest{
+		Type: slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "利用状況", false, false)),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, home.Usage, false, false), nil, nil),
+			slack.NewDividerBlock(),
+			slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "個人設定", false, false)),
+			slack.NewSectionBlock(
+				slack.NewTextBlockObject(slack.MarkdownType, home.Settings, false, false),
+				nil,
+				slack.NewAccessory(slack.NewButtonBlockElement(
+					EditSettingsActionID,
+					EditSettingsActionID,
+					slack.NewTextBlockObject(slack.PlainTextType, ":gear: 設定を変更", true, false),
+				)),
+			),
+			slack.NewSectionBlock(
+				slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("*個人的な指示*\n%s", instructions), false, false),
+				nil,
+				slack.NewAccessory(slack.NewButtonBlockElement(
+					EditInstructionsActionID,
+					EditInstructionsActionID,
+					slack.NewTextBlockObject(slack.PlainTextType, ":pencil2: 指示を編集", true, false),
+				)),
+			),
+			slack.NewDividerBlock(),
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, HomeHelpText, false, false), nil, nil),
+		}},
+	}
+}
@@ -0,0 +1,321 @@
+package slackapi
+
+import (
+	"github.com/slack-go/slack"
+	"golang.org/x/text/width"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// SectionCollapseThreshold - 一つのセクションブロックに入れる文字数の目安
+	// これを超えるとSlackが「続きを表示」で折りたたんでしまうため、超える前に次のブロックに分けます
+	SectionCollapseThreshold = 700
+
+	// MaxBlocksPerMessage - 一つのメッセージに含められるブロックの最大数
+	MaxBlocksPerMessage = 50
+
+	codeFence = "```"
+)
+
+var (
+	headingPattern        = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	unorderedListPattern  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	orderedListPattern    = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	quotePattern          = regexp.MustCompile(`^\s{0,3}>\s?(.*)$`)
+	ruleLinePattern       = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	tableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+
+	imagePattern         = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(\s+"[^"]*")?\)`)
+	linkPattern          = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(\s+"[^"]*")?\)`)
+	boldPattern          = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	italicPattern        = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*]*?\S)?)\*`)
+	strikethroughPattern = regexp.MustCompile(`~~(\S(?:.*?\S)?)~~`)
+	slackTokenPattern    = regexp.MustCompile(`<(?:[@#!][^<>\s]*|https?://[^<>\s]+|mailto:[^<>\s]+)>`)
+)
+
+var controlCharsReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// bullets - 箇条書きの深さごとの記号
+var bullets = []string{"•", "◦", "▪"}
+
+// ConvertMarkdown - モデルが出力したMarkdownを、Slackのmrkdwn形式に変換します
+// 見出し・太字・斜体・取り消し線・リンク・箇条書き・引用に対応し、表はコードブロックとして整形します
+// 閉じられていないコードブロック (生成中の回答等) は末尾で閉じます
+func ConvertMarkdown(markdown string) string {
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+
+	var out []string
+	inCode := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		trimmed := strings.TrimSpace(line)
+		if !inCode && strings.HasPrefix(trimmed, codeFence) && !isFenceLine(trimmed) {
+			// 一行で閉じているコードブロックはSlackでもそのまま表示できる
+			out = append(out, escapeMrkdwn(line))
+			continue
+		}
+		if isFenceLine(trimmed) {
+			// 言語の指定はSlackでは表示できないため取り除く
+			inCode = !inCode
+			out = append(out, codeFence)
+			continue
+		}
+		if inCode {
+			out = append(out, escapeMrkdwn(line))
+			continue
+		}
+
+		if isTableRow(line) && i+1 < len(lines) && tableSeparatorPattern.MatchString(lines[i+1]) {
+			end := i + 2
+			for end < len(lines) && isTableRow(lines[end]) {
+				end++
+			}
+			out = append(out, convertTable(append([]string{lines[i]}, lines[i+2:end]...))...)
+			i = end - 1
+			continue
+		}
+
+		out = append(out, convertLine(line))
+	}
+	if inCode {
+		out = append(out, codeFence)
+	}
+
+	return strings.Join(out, "\n")
+}
+
+// convertLine - コードブロック以外の一行を変換します
+func convertLine(line string) string {
+	if ruleLinePattern.MatchString(line) {
+		return "──────────"
+	}
+	if m := headingPattern.FindStringSubmatch(line); m != nil {
+		return "*" + convertInline(m[1], false) + "*"
+	}
+	if m := quotePattern.FindStringSubmatch(line); m != nil {
+		// Slackでは引用を入れ子にできないため、一段にまとめる
+		inner := m[1]
+		for {
+			next := quotePattern.FindStringSubmatch(inner)
+			if next == nil {
+				break
+			}
+			inner = next[1]
+		}
+		return "> " + convertLine(inner)
+	}
+	if m := unorderedListPattern.FindStringSubmatch(line); m != nil {
+		depth := indentDepth(m[1])
+		bullet := bullets[depth%len(bullets)]
+		return strings.Repeat("    ", depth) + bullet + " " + convertInline(m[2], true)
+	}
+	if m := orderedListPattern.FindStringSubmatch(line); m != nil {
+		return strings.Repeat("    ", indentDepth(m[1])) + m[2] + ". " + convertInline(m[3], true)
+	}
+
+	return convertInline(line, true)
+}
+
+// convertInline - インラインの書式を変換します。インラインコードの中は変換しません
+func convertInline(text string, keepBold bool) string {
+	segments := strings.Split(text, "`")
+	for i := range segments {
+		// 奇数番目はインラインコードの中 (閉じられていない場合も含む)
+		if i%2 == 1 && i < len(segments)-1 {
+			segments[i] = escapeMrkdwn(segments[i])
+			continue
+		}
+
+		s := escapeMrkdwn(segments[i])
+		s = imagePattern.ReplaceAllString(s, "<$2|$1>")
+		s = linkPattern.ReplaceAllString(s, "<$2|$1>")
+		s = strikethroughPattern.ReplaceAllString(s, "~$1~")
+
+		// 太字を一旦目印に置き換えてから斜体を変換し、最後に太字に戻す
+		s = boldPattern.ReplaceAllStringFunc(s, func(match string) string {
+			inner := match[2 : len(match)-2]
+			if !keepBold {
+				return inner
+			}
+			return "\x00" + inner + "\x00"
+		})
+		s = italicPattern.ReplaceAllString(s, "${1}_${2}_")
+		s = strings.ReplaceAll(s, "\x00", "*")
+
+		segments[i] = s
+	}
+	return strings.Join(segments, "`")
+}
+
+// escapeMrkdwn - Slackが制御文字として扱う &, <, > をエスケープします。メンションやリンク等のSlackの記法はそのまま残します
+func escapeMrkdwn(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range slackTokenPattern.FindAllStringIndex(text, -1) {
+		b.WriteString(escapeControlChars(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(escapeControlChars(text[last:]))
+	return b.String()
+}
+
+// escapeControlChars - &, <, > をエスケープします
+// &lt; のような文字列をそのまま表示できるよう、& も &amp; にします (Replacerは一度で置き換えるため二重にはなりません)
+func escapeControlChars(text string) string {
+	return controlCharsReplacer.Replace(text)
+}
+
+// indentDepth - 箇条書きのインデントから入れ子の深さを求めます (2文字または1タブで一段)
+func indentDepth(indent string) int {
+	return len(strings.ReplaceAll(indent, "\t", "  ")) / 2
+}
+
+func isTableRow(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "|") && strings.Count(trimmed, "|") >= 2
+}
+
+// convertTable - 表を列の幅を揃えたコードブロックに変換します (区切り行は除いて渡します)
+func convertTable(rows []string) []string {
+	var cells [][]string
+	var widths []int
+	for _, row := range rows {
+		trimmed := strings.TrimSpace(row)
+		trimmed = strings.TrimPrefix(trimmed, "|")
+		trimmed = strings.TrimSuffix(trimmed, "|")
+
+		var cols []string
+		for i, col := range strings.Split(trimmed, "|") {
+			col = strings.TrimSpace(col)
+			col = strings.NewReplacer("**", "", "__", "", "`", "").Replace(col)
+			cols = append(cols, col)
+
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := displayWidth(col); w > widths[i] {
+				widths[i] = w
+			}
+		}
+		cells = append(cells, cols)
+	}
+
+	out := []string{codeFence}
+	for r, cols := range cells {
+		var line []string
+		for i, col := range cols {
+			line = append(line, col+strings.Repeat(" ", widths[i]-displayWidth(col)))
+		}
+		out = append(out, escapeMrkdwn(strings.TrimRight(strings.Join(line, " | "), " ")))
+
+		// 見出しの行の下に区切り線を入れる
+		if r == 0 {
+			var rule []string
+			for _, w := range widths[:len(cols)] {
+				rule = append(rule, strings.Repeat("-", w))
+			}
+			out = append(out, strings.Join(rule, "-+-"))
+		}
+	}
+	return append(out, codeFence)
+}
+
+// displayWidth - 等幅フォントで表示したときの幅を求めます (全角文字は2文字分)
+func displayWidth(text string) int {
+	w := 0
+	for _, r := range text {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			w += 2
+		default:
+			w++
+		}
+	}
+	return w
+}
+
+// buildMarkdownBlocks - Markdownをmrkdwnに変換し、折りたたまれない長さのセクションブロックに分けます
+// ブロック数が上限を超える場合はfalseを返します
+func buildMarkdownBlocks(markdown string) ([]slack.Block, bool) {
+	var blocks []slack.Block
+	for _, chunk := range splitMrkdwn(ConvertMarkdown(markdown), SectionCollapseThreshold) {
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, chunk, false, false),
+			nil,
+			nil,
+		))
+	}
+	return blocks, len(blocks) <= MaxBlocksPerMessage
+}
+
+// splitMrkdwn - mrkdwnを行の区切りでlimit文字程度ごとに分割します
+// コードブロックの途中で分割する場合は、前後のブロックでそれぞれ閉じて開き直します
+func splitMrkdwn(text string, limit int) []string {
+	var chunks []string
+	var current []string
+	size := 0
+	inCode := false
+	flush := func() {
+		// 開き直しただけで中身のないコードブロックは出力しない
+		if inCode && len(current) == 1 {
+			return
+		}
+		if inCode {
+			current = append(current, codeFence)
+		}
+		if chunk := strings.Join(current, "\n"); strings.TrimSpace(chunk) != "" {
+			chunks = append(chunks, chunk)
+		}
+		current, size = nil, 0
+		if inCode {
+			current, size = []string{codeFence}, len(codeFence)+1
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		lineSize := utf8.RuneCountInString(line) + 1
+		if size > 0 && size+lineSize > limit {
+			flush()
+		}
+
+		// 開き直した直後に閉じる場合は、空のコードブロックにならないよう開き直しを取り消す
+		if line == codeFence && inCode && len(current) == 1 {
+			current, size, inCode = nil, 0, false
+			continue
+		}
+		for lineSize > limit {
+			// 一行だけで上限を超える場合は、文の区切りか空白で区切る
+			head, tail := cutLine(line, limit)
+			current = append(current, head)
+			flush()
+			line = tail
+			lineSize = utf8.RuneCountInString(line) + 1
+		}
+
+		current = append(current, line)
+		size += lineSize
+		if line == codeFence {
+			inCode = !inCode
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// cutLine - 長い行をlimit文字以内で区切ります。後半に句点や空白があればその直後で区切ります
+func cutLine(line string, limit int) (string, string) {
+	runes := []rune(line)
+	cut := limit
+	for i := limit - 1; i > limit/2; i-- {
+		if runes[i] == '。' || runes[i] == ' ' {
+			cut = i + 1
+			break
+		}
+	}
+	return string(runes[:cut]), string(runes[cut:])
+}
@@ -0,0 +1,148 @@
+package slackapi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{
+			name:     "escape",
+			markdown: "a & b < c > d &lt; <@U123> <#C123> <https://example.com>",
+			want:     "a &amp; b &lt; c &gt; d &amp;lt; <@U123> <#C123> <https://example.com>",
+		},
+		{
+			name:     "heading",
+			markdown: "## **Go** の使い方 ##",
+			want:     "*Go の使い方*",
+		},
+		{
+			name:     "bold and italic",
+			markdown: "*italic* and **bold** and __bold__",
+			want:     "_italic_ and *bold* and *bold*",
+		},
+		{
+			name:     "italic inside bold",
+			markdown: "**bold *italic* bold**",
+			want:     "*bold _italic_ bold*",
+		},
+		{
+			name:     "bold link",
+			markdown: "**[docs](https://example.com/docs)**",
+			want:     "*<https://example.com/docs|docs>*",
+		},
+		{
+			name:     "link with query",
+			markdown: "[search](https://example.com/?q=go&lang=ja) ~~old~~",
+			want:     "<https://example.com/?q=go&amp;lang=ja|search> ~old~",
+		},
+		{
+			name:     "asterisk in text",
+			markdown: "2 * 3 * 4 = 24, a*b",
+			want:     "2 * 3 * 4 = 24, a*b",
+		},
+		{
+			name:     "inline code",
+			markdown: "`**x** < y` and **y**",
+			want:     "`**x** &lt; y` and *y*",
+		},
+		{
+			name:     "nested lists",
+			markdown: "- a\n  - b\n    * c\n      + d\n\t- e\n1. one\n   2) two",
+			want:     "• a\n    ◦ b\n        ▪ c\n            • d\n    ◦ e\n1. one\n    2. two",
+		},
+		{
+			name:     "quote",
+			markdown: "> > **note**",
+			want:     "> *note*",
+		},
+		{
+			name:     "code fence",
+			markdown: "```go\nif a < b && c {\n\t**x**\n}\n```\n**after**",
+			want:     "```\nif a &lt; b &amp;&amp; c {\n\t**x**\n}\n```\n*after*",
+		},
+		{
+			name:     "unclosed code fence",
+			markdown: "```\nfmt.Println(\"a\")",
+			want:     "```\nfmt.Println(\"a\")\n```",
+		},
+		{
+			name:     "table with full-width text",
+			markdown: "| 名前 | 値 |\n|:---|---:|\n| りんご | 100 |\n| b | **2** |\n\nend",
+			want:     "```\n名前   | 値\n-------+----\nりんご | 100\nb      | 2\n```\n\nend",
+		},
+		{
+			name:     "rule",
+			markdown: "a\n\n---\n\nb",
+			want:     "a\n\n──────────\n\nb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ConvertMarkdown(tt.markdown); got != tt.want {
+				t.Errorf("ConvertMarkdown() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitMrkdwn(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "short",
+			text:  "line1\nline2",
+			limit: 100,
+			want:  []string{"line1\nline2"},
+		},
+		{
+			name:  "split at line",
+			text:  "aaaa\nbbbb\ncccc",
+			limit: 10,
+			want:  []string{"aaaa\nbbbb", "cccc"},
+		},
+		{
+			name:  "code fence across chunks",
+			text:  "intro\n```\nline1\nline2\nline3\n```\nend",
+			limit: 20,
+			want:  []string{"intro\n```\nline1\n```", "```\nline2\nline3\n```", "end"},
+		},
+		{
+			name:  "no empty reopened fence",
+			text:  "```\n0123456789\n```\nend",
+			limit: 16,
+			want:  []string{"```\n0123456789\n```", "end"},
+		},
+		{
+			name:  "long line",
+			text:  "これは長い文です。次の文です。",
+			limit: 10,
+			want:  []string{"これは長い文です。", "次の文です。"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMrkdwn(tt.text, tt.limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMrkdwn() = %q, want %q", got, tt.want)
+			}
+			for _, chunk := range got {
+				if strings.Count(chunk, codeFence)%2 != 0 {
+					t.Errorf("chunk has an unclosed code fence: %q", chunk)
+				}
+			}
+		})
+	}
+}
@@ -3,32 +3,68 @@ package slackapi
 import (
 	"fmt"
 	"github.com/slack-go/slack"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// SafeMessageLength - 一つのメッセージに確定させる回答の文字数の上限
+	// Slackのテキストの上限よりも十分に小さくし、超えた分は同じスレッドの次のメッセージに続けます
+	SafeMessageLength = 3000
+
+	// splitMargin - 確定させずに残しておく末尾の文字数
+	// 生成中は末尾に「...」やツールの進捗等の一時的な表示が付くため、それらが確定した内容に含まれないようにします
+	splitMargin = 500
+
+	// pendingPartMessage - 続きを入れるメッセージを投稿した直後の表示
+	pendingPartMessage = "..."
+
+	// partSeparator - ボタンのvalueに、回答を構成するメッセージのタイムスタンプを並べる際の区切り
+	partSeparator = ","
 )
 
 type (
 	BotMessage interface {
+		// UpdateMessage - 回答の全文でメッセージを更新します。長い場合は複数のメッセージに分けて投稿します
 		UpdateMessage(message string, isUpdating bool) error
 
 		Regenerate(initialMessage string)
 
+		// OutputTimeStamp - 回答の最初のメッセージのタイムスタンプ (回答を識別するために使います)
 		OutputTimeStamp() string
 
 		DeleteMySelf() error
+
+		// SetModel - 応答したモデル名を設定します。以降のコントローラーの更新時に表示されます
+		SetModel(model string)
 	}
 
 	botMessage struct {
 		webapi       *slack.Client
 		channelID    string
+		threadTS     string
 		outputTS     string
 		controllerTS string
+		model        string
+
+		// parts - 回答を構成するメッセージのタイムスタンプ。先頭はoutputTSです
+		parts []string
+
+		// offsets - 各メッセージに入れている内容が、回答の全文の何バイト目から始まるか
+		offsets []int
+
+		// finalized - 最後のメッセージより前のメッセージに入れ終わった回答の内容
+		finalized string
 	}
 )
 
-func (b botMessage) Regenerate(msg string) {
+func (b *botMessage) Regenerate(msg string) {
+	b.collapse()
+
 	go b.webapi.UpdateMessage(
 		b.channelID,
 		b.controllerTS,
-		slack.MsgOptionBlocks(buildActionBlock(true, b.outputTS)),
+		slack.MsgOptionBlocks(buildControllerBlocks(true, b.parts, b.model)...),
 	)
 
 	go b.webapi.UpdateMessage(
@@ -38,56 +74,134 @@ func (b botMessage) Regenerate(msg string) {
 	)
 }
 
-func (b botMessage) DeleteMySelf() error {
-	go b.webapi.DeleteMessage(b.channelID, b.outputTS)
+func (b *botMessage) SetModel(model string) {
+	b.model = model
+}
+
+func (b *botMessage) DeleteMySelf() error {
+	for _, ts := range b.parts {
+		go b.webapi.DeleteMessage(b.channelID, ts)
+	}
 	go b.webapi.DeleteMessage(b.channelID, b.controllerTS)
 	return nil
 }
 
-func (b botMessage) OutputTimeStamp() string {
+func (b *botMessage) OutputTimeStamp() string {
 	return b.outputTS
 }
 
-func (b botMessage) UpdateMessage(message string, isUpdating bool) error {
+func (b *botMessage) UpdateMessage(message string, isUpdating bool) error {
+	// 回答が先頭から置き換えられた場合 (エラー表示等) や、引き継いだ回答を書き換える場合は、最初のメッセージだけに戻す
+	if len(b.parts) > 1 && (b.finalized == "" || !strings.HasPrefix(message, b.finalized)) {
+		b.collapse()
+	}
+
+	for {
+		last := len(b.parts) - 1
+		start := b.offsets[last]
+		if utf8.RuneCountInString(message[start:]) <= SafeMessageLength+splitMargin {
+			break
+		}
+
+		// 今のメッセージを段落の区切りで確定させ、続きを新しいメッセージに投稿する
+		end := start + splitPoint(message[start:], SafeMessageLength)
+		err := b.updatePart(b.parts[last], partText(message, start, end))
+		if err != nil {
+			return err
+		}
+
+		err = b.appendPart(message[:end], end)
+		if err != nil {
+			return err
+		}
+	}
+
 	if !isUpdating {
 		go b.webapi.UpdateMessage(
 			b.channelID,
 			b.controllerTS,
-			slack.MsgOptionBlocks(buildActionBlock(false, b.outputTS)),
+			slack.MsgOptionBlocks(buildControllerBlocks(false, b.parts, b.model)...),
 		)
 	}
 
-	_, _, _, err := b.webapi.UpdateMessage(b.channelID, b.outputTS, slack.MsgOptionText(message, false))
-
-	// マークダウン形式に対応できるが、長い出力の場合Slackの仕様上See Moreを押さないと表示されない😕
-	// この問題が解決できるまではプレーンテキストで対応する
-	//_, _, _, err := b.webapi.UpdateMessage(
-	//	b.channelID,
-	//	b.outputTS,
-	//	slack.MsgOptionBlocks(
-	//		b.buildActionBlock(isUpdating),
-	//		slack.NewSectionBlock(
-	//			slack.NewTextBlockObject(
-	//				slack.MarkdownType,
-	//				message,
-	//				false,
-	//				false,
-	//			),
-	//			nil,
-	//			nil,
-	//		),
-	//	),
-	//)
+	last := len(b.parts) - 1
+	return b.updatePart(b.parts[last], partText(message, b.offsets[last], len(message)))
+}
+
+// updatePart - 回答を構成するメッセージの一つを更新します
+func (b *botMessage) updatePart(ts string, message string) error {
+	// 会話を読み込み直す際にはtextを使うため、textには元のMarkdownをそのまま入れておく
+	options := []slack.MsgOption{slack.MsgOptionText(message, false)}
+	if blocks, ok := buildMarkdownBlocks(message); ok {
+		options = append(options, slack.MsgOptionBlocks(blocks...))
+	}
+
+	_, _, _, err := b.webapi.UpdateMessage(b.channelID, ts, options...)
 
 	return err
 }
 
-func buildActionBlock(addStopButton bool, targetTimeStamp string) *slack.ActionBlock {
+// appendPart - 回答の続きを入れるメッセージを投稿し、コントローラーをその下に移動します
+func (b *botMessage) appendPart(finalized string, offset int) error {
+	_, ts, err := b.webapi.PostMessage(
+		b.channelID,
+		slack.MsgOptionText(pendingPartMessage, false),
+		slack.MsgOptionTS(b.threadTS),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to post message: %v", err)
+	}
+
+	b.parts = append(b.parts, ts)
+	b.offsets = append(b.offsets, offset)
+	b.finalized = finalized
+
+	go b.webapi.DeleteMessage(b.channelID, b.controllerTS)
+	_, controllerTS, err := b.webapi.PostMessage(
+		b.channelID,
+		slack.MsgOptionTS(b.threadTS),
+		slack.MsgOptionBlocks(buildControllerBlocks(true, b.parts, b.model)...),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to post message: %v", err)
+	}
+	b.controllerTS = controllerTS
+
+	return nil
+}
+
+// collapse - 2つ目以降のメッセージを削除し、回答を最初のメッセージだけに戻します
+func (b *botMessage) collapse() {
+	for _, ts := range b.parts[1:] {
+		go b.webapi.DeleteMessage(b.channelID, ts)
+	}
+	b.parts = b.parts[:1]
+	b.offsets = b.offsets[:1]
+	b.finalized = ""
+}
+
+// buildControllerBlocks - コントローラーのブロックを作成します。モデル名が指定されていれば併せて表示します
+func buildControllerBlocks(addStopButton bool, parts []string, model string) []slack.Block {
+	blocks := []slack.Block{buildActionBlock(addStopButton, parts)}
+	if model != "" {
+		blocks = append(blocks, slack.NewContextBlock(
+			"",
+			slack.NewTextBlockObject(slack.MarkdownType, ":robot_face: "+model, false, false),
+		))
+	}
+	return blocks
+}
+
+// buildActionBlock - 停止・再生成・削除のボタンを作成します
+// block_idには回答の最初のメッセージのタイムスタンプを、ボタンのvalueには回答を構成するすべてのメッセージのタイムスタンプを入れます
+func buildActionBlock(addStopButton bool, parts []string) *slack.ActionBlock {
+	value := strings.Join(parts, partSeparator)
+
 	var elements []slack.BlockElement
 	if addStopButton {
 		elements = append(elements, slack.NewButtonBlockElement(
 			"stop",
-			"stop",
+			value,
 			slack.NewTextBlockObject(
 				slack.PlainTextType,
 				":x: 停止",
@@ -98,7 +212,7 @@ func buildActionBlock(addStopButton bool, targetTimeStamp string) *slack.ActionB
 	}
 	elements = append(elements, slack.NewButtonBlockElement(
 		"regenerate",
-		"regenerate",
+		value,
 		slack.NewTextBlockObject(
 			slack.PlainTextType,
 			":recycle: 再生成",
@@ -108,7 +222,7 @@ func buildActionBlock(addStopButton bool, targetTimeStamp string) *slack.ActionB
 	))
 	elements = append(elements, slack.NewButtonBlockElement(
 		"delete",
-		"delete",
+		value,
 		slack.NewTextBlockObject(
 			slack.PlainTextType,
 			":fire: 削除",
@@ -117,15 +231,85 @@ func buildActionBlock(addStopButton bool, targetTimeStamp string) *slack.ActionB
 		),
 	))
 
-	return slack.NewActionBlock(targetTimeStamp, elements...)
+	return slack.NewActionBlock(parts[0], elements...)
+}
+
+// ParsePartTimeStamps - ボタンのvalueから、回答を構成するメッセージのタイムスタンプを取り出します
+// 分割に対応する前に投稿されたボタンの場合は、block_idの最初のメッセージだけを返します
+func ParsePartTimeStamps(value string, outputTS string) []string {
+	if !strings.Contains(value, ".") {
+		return []string{outputTS}
+	}
+	return strings.Split(value, partSeparator)
 }
 
-func TakeOverBotMessage(webapi *slack.Client, channelID string, outputTS string, controllerTS string) BotMessage {
+// GroupAnswerParts - 分割された回答の続きのメッセージを、回答の最初のメッセージの直後に並べ替えます
+// 回答の生成中に投稿された他のメッセージ (次の質問や順番待ちの回答等) よりも、続きのメッセージが後に投稿されている場合があるためです
+// 回答を構成するメッセージは、ボットが投稿したコントローラーのボタンのvalueから取り出します
+func GroupAnswerParts(messages []slack.Message, botUserID string) []slack.Message {
+	continuations := make(map[string][]string)
+	moved := make(map[string]bool)
+	for _, m := range messages {
+		if m.User != botUserID || len(m.Blocks.BlockSet) == 0 {
+			continue
+		}
+		block, ok := m.Blocks.BlockSet[0].(*slack.ActionBlock)
+		if !ok || block.Elements == nil || len(block.Elements.ElementSet) == 0 {
+			continue
+		}
+		button, ok := block.Elements.ElementSet[0].(*slack.ButtonBlockElement)
+		if !ok {
+			continue
+		}
+
+		parts := ParsePartTimeStamps(button.Value, block.BlockID)
+		if len(parts) <= 1 {
+			continue
+		}
+		continuations[parts[0]] = parts[1:]
+		for _, ts := range parts[1:] {
+			moved[ts] = true
+		}
+	}
+	if len(continuations) == 0 {
+		return messages
+	}
+
+	byTimestamp := make(map[string]slack.Message, len(messages))
+	for _, m := range messages {
+		byTimestamp[m.Timestamp] = m
+	}
+
+	grouped := make([]slack.Message, 0, len(messages))
+	for _, m := range messages {
+		if moved[m.Timestamp] {
+			continue
+		}
+		grouped = append(grouped, m)
+		for _, ts := range continuations[m.Timestamp] {
+			if part, ok := byTimestamp[ts]; ok {
+				grouped = append(grouped, part)
+			}
+		}
+	}
+	return grouped
+}
+
+// TakeOverBotMessage - 投稿済みの回答を操作するためのBotMessageを作成します
+// partsには回答を構成するメッセージのタイムスタンプを、最初のメッセージ (outputTS) から順に指定します
+func TakeOverBotMessage(webapi *slack.Client, channelID string, threadTS string, outputTS string, controllerTS string, parts []string) BotMessage {
+	if len(parts) == 0 || parts[0] != outputTS {
+		parts = []string{outputTS}
+	}
+
 	return &botMessage{
 		webapi:       webapi,
 		channelID:    channelID,
+		threadTS:     threadTS,
 		outputTS:     outputTS,
 		controllerTS: controllerTS,
+		parts:        parts,
+		offsets:      make([]int, len(parts)),
 	}
 }
 
@@ -144,7 +328,7 @@ func NewBotMessage(webapi *slack.Client, channelID string, threadTS string, init
 	_, controllerMessageTS, err := webapi.PostMessage(
 		channelID,
 		slack.MsgOptionTS(threadTS),
-		slack.MsgOptionBlocks(buildActionBlock(true, respTimeStamp)),
+		slack.MsgOptionBlocks(buildActionBlock(true, []string{respTimeStamp})),
 	)
 
 	if err != nil {
@@ -154,7 +338,10 @@ func NewBotMessage(webapi *slack.Client, channelID string, threadTS string, init
 	return &botMessage{
 		webapi:       webapi,
 		channelID:    channelID,
+		threadTS:     threadTS,
 		outputTS:     respTimeStamp,
 		controllerTS: controllerMessageTS,
+		parts:        []string{respTimeStamp},
+		offsets:      []int{0},
 	}, nil
 }
@@ -0,0 +1,208 @@
+package slackapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/slack-go/slack"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	// fakeSlackServer - chat.postMessage, chat.update, chat.delete を受け付けて記録するSlack APIのサーバー
+	fakeSlackServer struct {
+		mu       sync.Mutex
+		calls    []slackCall
+		posted   int
+		messages map[string]string
+		deleted  map[string]bool
+	}
+
+	slackCall struct {
+		method string
+		ts     string
+		text   string
+		blocks string
+	}
+)
+
+func newFakeSlackServer(t *testing.T) (*fakeSlackServer, *slack.Client) {
+	f := &fakeSlackServer{
+		messages: make(map[string]string),
+		deleted:  make(map[string]bool),
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/"))
+}
+
+func (f *fakeSlackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := slackCall{
+		method: strings.TrimPrefix(r.URL.Path, "/"),
+		ts:     r.FormValue("ts"),
+		text:   r.FormValue("text"),
+		blocks: r.FormValue("blocks"),
+	}
+	switch call.method {
+	case "chat.postMessage":
+		f.posted++
+		call.ts = fmt.Sprintf("1700000000.%06d", f.posted)
+		f.messages[call.ts] = call.text
+	case "chat.update":
+		f.messages[call.ts] = call.text
+	case "chat.delete":
+		f.deleted[call.ts] = true
+	}
+	f.calls = append(f.calls, call)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "channel": "C1", "ts": call.ts})
+}
+
+// waitFor - 非同期に送信されるリクエストが届くまで待ちます
+func (f *fakeSlackServer) waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		f.mu.Lock()
+		ok := cond()
+		f.mu.Unlock()
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func longAnswer(paragraphs int) string {
+	var b strings.Builder
+	for i := 0; i < paragraphs; i++ {
+		fmt.Fprintf(&b, "%d: %s\n\n", i, strings.Repeat("あ", 95))
+	}
+	return b.String()
+}
+
+func TestUpdateMessageSplitsLongAnswer(t *testing.T) {
+	f, client := newFakeSlackServer(t)
+
+	message, err := NewBotMessage(client, "C1", "1699999999.000001", "...")
+	if err != nil {
+		t.Fatalf("NewBotMessage() error = %v", err)
+	}
+	b := message.(*botMessage)
+
+	answer := longAnswer(80)
+	err = b.UpdateMessage(answer, false)
+	if err != nil {
+		t.Fatalf("UpdateMessage() error = %v", err)
+	}
+
+	if len(b.parts) != 3 {
+		t.Fatalf("parts = %d, want 3", len(b.parts))
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var joined []string
+	for _, ts := range b.parts {
+		text := f.messages[ts]
+		if n := len([]rune(text)); n > SafeMessageLength+splitMargin {
+			t.Errorf("part %s has %d characters", ts, n)
+		}
+		if !strings.HasSuffix(text, strings.Repeat("あ", 95)) {
+			t.Errorf("part %s is not split at a paragraph", ts)
+		}
+		joined = append(joined, text)
+	}
+	if got := strings.Join(joined, "\n\n"); got != strings.TrimSuffix(answer, "\n\n") {
+		t.Errorf("joined parts do not match the answer")
+	}
+}
+
+func TestRegenerateCollapsesParts(t *testing.T) {
+	f, client := newFakeSlackServer(t)
+
+	parts := []string{"1700000001.000001", "1700000001.000002", "1700000001.000003"}
+	message := TakeOverBotMessage(client, "C1", "1699999999.000001", parts[0], "1700000001.000009", parts)
+	b := message.(*botMessage)
+
+	message.Regenerate("再生成しています...")
+	f.waitFor(t, "deleting the extra parts", func() bool {
+		return f.deleted[parts[1]] && f.deleted[parts[2]] && f.messages[parts[0]] == "再生成しています..."
+	})
+	if len(b.parts) != 1 || b.parts[0] != parts[0] {
+		t.Fatalf("parts after regenerate = %v", b.parts)
+	}
+
+	err := message.UpdateMessage("短い回答です。", false)
+	if err != nil {
+		t.Fatalf("UpdateMessage() error = %v", err)
+	}
+
+	// 回答は最初のメッセージだけに入り、コントローラーのボタンも最初のメッセージだけを指す
+	f.waitFor(t, "updating the controller", func() bool {
+		for _, call := range f.calls {
+			if call.method == "chat.update" && call.ts == "1700000001.000009" && !strings.Contains(call.blocks, "stop") {
+				return true
+			}
+		}
+		return false
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := f.messages[parts[0]]; got != "短い回答です。" {
+		t.Errorf("first part = %q", got)
+	}
+	for _, call := range f.calls {
+		if call.method == "chat.postMessage" {
+			t.Errorf("unexpected new message: %q", call.text)
+		}
+		if call.method == "chat.update" && call.ts == "1700000001.000009" && strings.Contains(call.blocks, parts[1]) {
+			t.Errorf("controller still refers to a deleted part: %s", call.blocks)
+		}
+	}
+}
+
+func TestUpdateMessageCollapsesRewrittenAnswer(t *testing.T) {
+	f, client := newFakeSlackServer(t)
+
+	message, err := NewBotMessage(client, "C1", "1699999999.000001", "...")
+	if err != nil {
+		t.Fatalf("NewBotMessage() error = %v", err)
+	}
+	b := message.(*botMessage)
+
+	err = message.UpdateMessage(longAnswer(40), true)
+	if err != nil {
+		t.Fatalf("UpdateMessage() error = %v", err)
+	}
+	if len(b.parts) != 2 {
+		t.Fatalf("parts = %d, want 2", len(b.parts))
+	}
+	extra := b.parts[1]
+
+	// エラー表示等で回答が先頭から置き換えられた場合は、最初のメッセージだけに戻す
+	err = message.UpdateMessage("エラーが発生しました。", false)
+	if err != nil {
+		t.Fatalf("UpdateMessage() error = %v", err)
+	}
+	f.waitFor(t, "deleting the extra part", func() bool {
+		return f.deleted[extra]
+	})
+	if len(b.parts) != 1 {
+		t.Errorf("parts = %d, want 1", len(b.parts))
+	}
+}
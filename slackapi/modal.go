@@ -0,0 +1,280 @@
+package slackapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// AskShortcutCallbackID - 「このメッセージについてボットに質問」ショートカットのCallback ID
+	AskShortcutCallbackID = "ask_about_message"
+
+	// AskModalCallbackID - 質問を入力するモーダルのCallback ID
+	AskModalCallbackID = "ask_about_message_modal"
+
+	AskPromptBlockID        = "prompt"
+	AskPromptActionID       = "prompt"
+	AskModelBlockID         = "model"
+	AskModelActionID        = "model"
+	AskOptionsBlockID       = "options"
+	AskOptionsActionID      = "options"
+	AskIncludeThreadOption  = "include_thread"
+	maxModalPrivateMetadata = 3000
+)
+
+type (
+	// AskModalMetadata - 質問の対象のメッセージ。モーダルのprivate_metadataに保存して送信時に受け取ります
+	AskModalMetadata struct {
+		ChannelID string `json:"channel_id"`
+		MessageTS string `json:"message_ts"`
+		ThreadTS  string `json:"thread_ts"`
+	}
+
+	// AskModalSubmission - モーダルで入力された内容
+	AskModalSubmission struct {
+		AskModalMetadata
+		Prompt        string
+		Model         string
+		IncludeThread bool
+	}
+)
+
+// OpenAskModal - メッセージについて質問するためのモーダルを開きます
+func (s slackAPI) OpenAskModal(triggerID string, metadata AskModalMetadata, models []string, defaultModel string) error {
+	privateMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal modal metadata: %v", err)
+	}
+	if len(privateMetadata) > maxModalPrivateMetadata {
+		return fmt.Errorf("modal metadata is too large: %d bytes", len(privateMetadata))
+	}
+
+	_, err = s.client.OpenView(triggerID, buildAskModal(string(privateMetadata), models, defaultModel))
+	if err != nil {
+		return fmt.Errorf("failed to open modal: %v", err)
+	}
+
+	return nil
+}
+
+// ParseAskModalSubmission - モーダルの送信内容を取り出します
+func ParseAskModalSubmission(view slack.View) (AskModalSubmission, error) {
+	var submission AskModalSubmission
+	err := json.Unmarshal([]byte(view.PrivateMetadata), &submission.AskModalMetadata)
+	if err != nil {
+		return submission, fmt.Errorf("failed to unmarshal modal metadata: %v", err)
+	}
+
+	values := view.State.Values
+	submission.Prompt = values[AskPromptBlockID][AskPromptActionID].Value
+	submission.Model = values[AskModelBlockID][AskModelActionID].SelectedOption.Value
+	for _, option := range values[AskOptionsBlockID][AskOptionsActionID].SelectedOptions {
+		if option.Value == AskIncludeThreadOption {
+			submission.IncludeThread = true
+		}
+	}
+
+	return submission, nil
+}
+
+func buildAskModal(privateMetadata string, models []string, defaultModel string) slack.ModalViewRequest {
+	prompt := slack.NewPlainTextInputBlockElement(
+		slack.NewTextBlockObject(slack.PlainTextType, "例: このエラーの原因と対処法を教えて", false, false),
+		AskPromptActionID,
+	)
+	prompt.Multiline = true
+
+	var options []*slack.OptionBlockObject
+	var initial *slack.OptionBlockObject
+	for _, model := range models {
+		option := slack.NewOptionBlockObject(model, slack.NewTextBlockObject(slack.PlainTextType, model, false, false), nil)
+		options = append(options, option)
+		if model == defaultModel {
+			initial = option
+		}
+	}
+	model := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, AskModelActionID, options...)
+	model.InitialOption = initial
+
+	includeThread := slack.NewCheckboxGroupsBlockElement(
+		AskOptionsActionID,
+		slack.NewOptionBlockObject(
+			AskIncludeThreadOption,
+			slack.NewTextBlockObject(slack.PlainTextType, "スレッド全体を会話に含める", false, false),
+			nil,
+		),
+	)
+	optionsBlock := slack.NewInputBlock(
+		AskOptionsBlockID,
+		slack.NewTextBlockObject(slack.PlainTextType, "オプション", false, false),
+		nil,
+		includeThread,
+	)
+	optionsBlock.Optional = true
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      AskModalCallbackID,
+		PrivateMetadata: privateMetadata,
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "ボットに質問", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "質問する", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "キャンセル", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(
+				AskPromptBlockID,
+				slack.NewTextBlockObject(slack.PlainTextType, "質問", false, false),
+				nil,
+				prompt,
+			),
+			slack.NewInputBlock(
+				AskModelBlockID,
+				slack.NewTextBlockObject(slack.PlainTextType, "モデル", false, false),
+				nil,
+				model,
+			),
+			optionsBlock,
+		}},
+	}
+}
+
+const (
+	// SettingsModalCallbackID - 個人設定を編集するモーダルのCallback ID
+	SettingsModalCallbackID = "user_settings_modal"
+
+	SettingsModelBlockID         = "model"
+	SettingsModelActionID        = "model"
+	SettingsLanguageBlockID      = "language"
+	SettingsLanguageActionID     = "language"
+	SettingsStyleBlockID         = "answer_style"
+	SettingsStyleActionID        = "answer_style"
+	SettingsTemperatureBlockID   = "temperature"
+	SettingsTemperatureActionID  = "temperature"
+	SettingsInstructionsBlockID  = "instructions"
+	SettingsInstructionsActionID = "instructions"
+
+	// SettingsDefaultValue - 「指定しない (ボットの既定に従う)」を選んだ場合の値
+	SettingsDefaultValue = "default"
+)
+
+type (
+	// SettingsChoice - 個人設定のモーダルに表示する選択肢
+	SettingsChoice struct {
+		Value string
+		Label string
+	}
+
+	// SettingsForm - 個人設定のモーダルに表示する内容。各項目が空の場合は「指定しない」を表します
+	SettingsForm struct {
+		Models       []SettingsChoice
+		Languages    []SettingsChoice
+		AnswerStyles []SettingsChoice
+		Temperatures []SettingsChoice
+
+		// DefaultModel - ボットの既定のモデル
+		DefaultModel string
+
+		Model        string
+		Language     string
+		AnswerStyle  string
+		Temperature  string
+		Instructions string
+
+		MaxInstructionsLength int
+	}
+)
+
+// OpenSettingsModal - 個人設定を編集するためのモーダルを開きます
+func (s slackAPI) OpenSettingsModal(triggerID string, form SettingsForm) error {
+	_, err := s.client.OpenView(triggerID, buildSettingsModal(form))
+	if err != nil {
+		return fmt.Errorf("failed to open modal: %v", err)
+	}
+
+	return nil
+}
+
+// ParseSettingsModalSubmission - 個人設定のモーダルの送信内容を取り出します
+func ParseSettingsModalSubmission(view slack.View) SettingsForm {
+	values := view.State.Values
+	selected := func(blockID string, actionID string) string {
+		value := values[blockID][actionID].SelectedOption.Value
+		if value == SettingsDefaultValue {
+			return ""
+		}
+		return value
+	}
+
+	return SettingsForm{
+		Model:        selected(SettingsModelBlockID, SettingsModelActionID),
+		Language:     selected(SettingsLanguageBlockID, SettingsLanguageActionID),
+		AnswerStyle:  selected(SettingsStyleBlockID, SettingsStyleActionID),
+		Temperature:  selected(SettingsTemperatureBlockID, SettingsTemperatureActionID),
+		Instructions: values[SettingsInstructionsBlockID][SettingsInstructionsActionID].Value,
+	}
+}
+
+func buildSettingsModal(form SettingsForm) slack.ModalViewRequest {
+	instructions := slack.NewPlainTextInputBlockElement(
+		slack.NewTextBlockObject(slack.PlainTextType, "例: 回答は結論から先に、簡潔に書いてください", false, false),
+		SettingsInstructionsActionID,
+	)
+	instructions.Multiline = true
+	instructions.InitialValue = form.Instructions
+	instructions.MaxLength = form.MaxInstructionsLength
+
+	instructionsBlock := slack.NewInputBlock(
+		SettingsInstructionsBlockID,
+		slack.NewTextBlockObject(slack.PlainTextType, "個人的な指示", false, false),
+		slack.NewTextBlockObject(slack.PlainTextType, "チャンネルの指示と矛盾する場合は、こちらが優先されます", false, false),
+		instructions,
+	)
+	instructionsBlock.Optional = true
+
+	return slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: SettingsModalCallbackID,
+		Title:      slack.NewTextBlockObject(slack.PlainTextType, "個人設定", false, false),
+		Submit:     slack.NewTextBlockObject(slack.PlainTextType, "保存", false, false),
+		Close:      slack.NewTextBlockObject(slack.PlainTextType, "キャンセル", false, false),
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			buildChoiceInput(SettingsModelBlockID, SettingsModelActionID, "既定のモデル",
+				fmt.Sprintf("ボットの既定 (%s)", form.DefaultModel), form.Models, form.Model),
+			buildChoiceInput(SettingsLanguageBlockID, SettingsLanguageActionID, "回答の言語",
+				"質問と同じ言語", form.Languages, form.Language),
+			buildChoiceInput(SettingsStyleBlockID, SettingsStyleActionID, "回答のスタイル",
+				"指定しない", form.AnswerStyles, form.AnswerStyle),
+			buildChoiceInput(SettingsTemperatureBlockID, SettingsTemperatureActionID, "temperature (回答のランダム性)",
+				"モデルの既定", form.Temperatures, form.Temperature),
+			instructionsBlock,
+		}},
+	}
+}
+
+// buildChoiceInput - 「指定しない」を先頭に加えた選択肢から選ぶ入力欄を作成します
+func buildChoiceInput(blockID string, actionID string, label string, defaultLabel string, choices []SettingsChoice, current string) *slack.InputBlock {
+	defaultOption := slack.NewOptionBlockObject(
+		SettingsDefaultValue,
+		slack.NewTextBlockObject(slack.PlainTextType, defaultLabel, false, false),
+		nil,
+	)
+	options := []*slack.OptionBlockObject{defaultOption}
+	initial := defaultOption
+	for _, choice := range choices {
+		option := slack.NewOptionBlockObject(choice.Value, slack.NewTextBlockObject(slack.PlainTextType, choice.Label, false, false), nil)
+		options = append(options, option)
+		if choice.Value == current {
+			initial = option
+		}
+	}
+
+	element := slack.NewOptionsSelectBlockElement(slack.OptTypeStatic, nil, actionID, options...)
+	element.InitialOption = initial
+
+	return slack.NewInputBlock(
+		blockID,
+		slack.NewTextBlockObject(slack.PlainTextType, label, false, false),
+		nil,
+		element,
+	)
+}
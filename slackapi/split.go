@@ -0,0 +1,84 @@
+package slackapi
+
+import (
+	"strings"
+)
+
+// splitPoint - 長い回答をlimit文字以内で区切る位置 (バイト数) を求めます
+// 段落の区切りかコードブロックの終わりを優先し、前半しか見つからない場合は行の区切り、それもなければ文字数で区切ります
+func splitPoint(text string, limit int) int {
+	limitByte := len(text)
+	count := 0
+	for i := range text {
+		if count == limit {
+			limitByte = i
+			break
+		}
+		count++
+	}
+
+	boundary, newline := 0, 0
+	inCode := false
+	for pos := 0; pos < limitByte; {
+		lineEnd := len(text)
+		if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+			lineEnd = pos + i + 1
+		}
+		if lineEnd > limitByte {
+			break
+		}
+
+		trimmed := strings.TrimSpace(text[pos:lineEnd])
+		if isFenceLine(trimmed) {
+			inCode = !inCode
+			if !inCode {
+				boundary = lineEnd
+			}
+		} else if trimmed == "" && !inCode {
+			boundary = lineEnd
+		}
+		newline = lineEnd
+		pos = lineEnd
+	}
+
+	// 区切りが前の方にしかない場合は、メッセージが細切れにならないよう行の区切りを使う
+	if boundary > limitByte/2 {
+		return boundary
+	}
+	if newline > 0 {
+		return newline
+	}
+	return limitByte
+}
+
+// partText - 回答の全文のうち[start, end)を一つのメッセージの内容にします
+// コードブロックの途中で区切られている場合は、前のメッセージで閉じて次のメッセージで開き直します
+func partText(message string, start int, end int) string {
+	text := strings.Trim(message[start:end], "\n")
+	if fenceOpen(message[:start]) {
+		text = codeFence + "\n" + text
+	}
+	if end < len(message) && fenceOpen(message[:end]) {
+		text += "\n" + codeFence
+	}
+	return text
+}
+
+// fenceOpen - 文章の終わりでコードブロックが開いたままかどうかを判定します
+func fenceOpen(text string) bool {
+	open := false
+	for _, line := range strings.Split(text, "\n") {
+		if isFenceLine(strings.TrimSpace(line)) {
+			open = !open
+		}
+	}
+	return open
+}
+
+// isFenceLine - コードブロックを開く・閉じる行かどうかを判定します (一行で閉じているものは含みません)
+func isFenceLine(trimmed string) bool {
+	if !strings.HasPrefix(trimmed, codeFence) {
+		return false
+	}
+	return len(trimmed) <= 2*len(codeFence) || !strings.HasSuffix(trimmed, codeFence)
+}
@@ -0,0 +1,125 @@
+package slackapi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPoint(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{
+			name:  "paragraph",
+			text:  "first paragraph\n\nsecond paragraph is long",
+			limit: 25,
+			want:  "first paragraph\n\n",
+		},
+		{
+			name:  "closed fence",
+			text:  "intro\n```\ncode\n```\nafter the code block",
+			limit: 25,
+			want:  "intro\n```\ncode\n```\n",
+		},
+		{
+			name:  "blank line in code is not a paragraph",
+			text:  "```\na\n\nb\n```\nrest rest rest",
+			limit: 12,
+			want:  "```\na\n\nb\n",
+		},
+		{
+			name:  "paragraph too early",
+			text:  "a\n\nbbbbbbbbbb\ncccccccccc\nddd",
+			limit: 25,
+			want:  "a\n\nbbbbbbbbbb\ncccccccccc\n",
+		},
+		{
+			name:  "no newline",
+			text:  "abcdefghij",
+			limit: 4,
+			want:  "abcd",
+		},
+		{
+			name:  "multibyte",
+			text:  "あいうえお",
+			limit: 2,
+			want:  "あい",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.text[:splitPoint(tt.text, tt.limit)]; got != tt.want {
+				t.Errorf("splitPoint() splits at %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartTextReopensFence(t *testing.T) {
+	message := "説明です。\n```go\nfunc a() {}\n\nfunc b() {}\n```\n以上です。"
+	end := strings.Index(message, "\nfunc b")
+
+	first := partText(message, 0, end)
+	if want := "説明です。\n```go\nfunc a() {}\n```"; first != want {
+		t.Errorf("first part = %q, want %q", first, want)
+	}
+
+	second := partText(message, end, len(message))
+	if want := "```\nfunc b() {}\n```\n以上です。"; second != want {
+		t.Errorf("second part = %q, want %q", second, want)
+	}
+}
+
+func TestSplitLongAnswer(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 20; i++ {
+		b.WriteString("段落の文章です。\n\n```\n")
+		for j := 0; j < 10; j++ {
+			b.WriteString("x := 1\n")
+		}
+		b.WriteString("```\n\n")
+	}
+	message := b.String()
+
+	// 段落かコードブロックの終わりで区切られ、分割したメッセージはそれぞれコードブロックが閉じている
+	var parts []string
+	for start := 0; start < len(message); {
+		end := start + splitPoint(message[start:], 100)
+		if end < len(message) && !strings.HasSuffix(message[:end], "\n\n") && !strings.HasSuffix(message[:end], "```\n") {
+			t.Errorf("split in the middle of a paragraph: %q", message[start:end])
+		}
+
+		part := partText(message, start, end)
+		if fenceOpen(part) {
+			t.Errorf("part has an unclosed code fence: %q", part)
+		}
+		parts = append(parts, part)
+		start = end
+	}
+	if len(parts) < 2 {
+		t.Fatalf("parts = %d, want several", len(parts))
+	}
+}
+
+func TestFenceOpen(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: "", want: false},
+		{text: "```go\ncode", want: true},
+		{text: "```\ncode\n```", want: false},
+		{text: "```inline```\ntext", want: false},
+		{text: "  ```\nindented", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := fenceOpen(tt.text); got != tt.want {
+			t.Errorf("fenceOpen(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // コンテナ等でタイムゾーンのデータベースが存在しない場合に備える
+)
+
+const (
+	// DefaultTimeZone - ユーザーのタイムゾーンが取得できなかった場合に利用するタイムゾーン
+	DefaultTimeZone = "Asia/Tokyo"
+)
+
+// NewCurrentTimeTool - ユーザーのタイムゾーンでの現在時刻を返すツール
+func NewCurrentTimeTool(slack slackapi.SlackAPI, log logger.Logger) Tool {
+	return Tool{
+		Name:            "get_current_time",
+		Description:     "Returns the current date and time in the timezone of the user who is talking to you.",
+		Parameters:      json.RawMessage(`{"type":"object","properties":{}}`),
+		ProgressMessage: ":clock3: 現在時刻を確認しています…",
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			tz := DefaultTimeZone
+			if env, ok := EnvironmentFrom(ctx); ok && env.UserID != "" {
+				userTZ, err := slack.GetUserTimeZone(env.UserID)
+				if err != nil {
+					log.Log(logger.WARN, "failed to get user timezone, use %s: %v", DefaultTimeZone, err)
+				} else if userTZ != "" {
+					tz = userTZ
+				}
+			}
+
+			loc, err := time.LoadLocation(tz)
+			if err != nil {
+				return "", fmt.Errorf("failed to load location %s: %v", tz, err)
+			}
+
+			now := time.Now().In(loc)
+			return fmt.Sprintf("%s (%s, timezone: %s)", now.Format(time.RFC3339), now.Weekday(), tz), nil
+		},
+	}
+}
+
+// NewCalculatorTool - 四則演算の式を計算するツール
+func NewCalculatorTool() Tool {
+	return Tool{
+		Name:            "calculate",
+		Description:     "Evaluates an arithmetic expression. Supports + - * / % ^ and parentheses. Use '.' as the decimal point and no thousands separators. Use this instead of calculating by yourself.",
+		Parameters:      json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string","description":"Arithmetic expression to evaluate, e.g. (1.5 + 2) * 3"}},"required":["expression"]}`),
+		ProgressMessage: ":abacus: 計算しています…",
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct {
+				Expression string `json:"expression"`
+			}
+			err := json.Unmarshal([]byte(arguments), &args)
+			if err != nil {
+				return "", fmt.Errorf("invalid arguments: %v", err)
+			}
+
+			result, err := Evaluate(args.Expression)
+			if err != nil {
+				return "", err
+			}
+
+			return strconv.FormatFloat(result, 'g', -1, 64), nil
+		},
+	}
+}
+
+// NewThreadMetadataTool - 現在のスレッドの情報を返すツール
+func NewThreadMetadataTool(slack slackapi.SlackAPI) Tool {
+	return Tool{
+		Name:            "get_thread_metadata",
+		Description:     "Returns metadata of the current Slack thread: channel, thread timestamp, start and last message time, number of messages and participants.",
+		Parameters:      json.RawMessage(`{"type":"object","properties":{}}`),
+		ProgressMessage: ":thread: スレッドの情報を確認しています…",
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			env, ok := EnvironmentFrom(ctx)
+			if !ok || env.ChannelID == "" || env.ThreadTS == "" {
+				return "", fmt.Errorf("thread information is not available")
+			}
+
+			messages, err := slack.LoadConversationReplies(env.ChannelID, env.ThreadTS)
+			if err != nil {
+				return "", err
+			}
+
+			var participants []string
+			seen := make(map[string]bool)
+			for _, m := range messages {
+				if m.User == "" || seen[m.User] {
+					continue
+				}
+				seen[m.User] = true
+				participants = append(participants, "<@"+m.User+">")
+			}
+
+			metadata := map[string]interface{}{
+				"channel":       "<#" + env.ChannelID + ">",
+				"thread_ts":     env.ThreadTS,
+				"message_count": len(messages),
+				"participants":  participants,
+				"requested_by":  "<@" + env.UserID + ">",
+			}
+			if len(messages) > 0 {
+				metadata["started_at"] = formatSlackTimestamp(messages[0].Timestamp)
+				metadata["last_message_at"] = formatSlackTimestamp(messages[len(messages)-1].Timestamp)
+			}
+
+			result, err := json.Marshal(metadata)
+			if err != nil {
+				return "", err
+			}
+			return string(result), nil
+		},
+	}
+}
+
+// formatSlackTimestamp - Slackのタイムスタンプ (1700000000.000000) をRFC3339形式に変換します
+func formatSlackTimestamp(ts string) string {
+	seconds, err := strconv.ParseInt(strings.SplitN(ts, ".", 2)[0], 10, 64)
+	if err != nil {
+		return ts
+	}
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
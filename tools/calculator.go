@@ -0,0 +1,199 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+type (
+	// calculator - 四則演算の式を評価する再帰下降パーサー
+	//
+	//	expr   = term { ("+" | "-") term }
+	//	term   = unary { ("*" | "/" | "%") unary }
+	//	unary  = ("+" | "-") unary | power
+	//	power  = factor [ "^" unary ]
+	//	factor = number | "(" expr ")"
+	calculator struct {
+		input []rune
+		pos   int
+	}
+)
+
+// Evaluate - 四則演算の式を評価します
+func Evaluate(expression string) (float64, error) {
+	c := &calculator{input: []rune(expression)}
+
+	result, err := c.expr()
+	if err != nil {
+		return 0, err
+	}
+
+	c.skipSpaces()
+	if c.pos < len(c.input) {
+		return 0, fmt.Errorf("unexpected character %q at %d", c.input[c.pos], c.pos)
+	}
+
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+
+	return result, nil
+}
+
+func (c *calculator) expr() (float64, error) {
+	left, err := c.term()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		switch c.peek() {
+		case '+':
+			c.pos++
+			right, err := c.term()
+			if err != nil {
+				return 0, err
+			}
+			left += right
+		case '-':
+			c.pos++
+			right, err := c.term()
+			if err != nil {
+				return 0, err
+			}
+			left -= right
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (c *calculator) term() (float64, error) {
+	left, err := c.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := c.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		c.pos++
+
+		right, err := c.unary()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+func (c *calculator) unary() (float64, error) {
+	switch c.peek() {
+	case '+':
+		c.pos++
+		return c.unary()
+	case '-':
+		c.pos++
+		value, err := c.unary()
+		return -value, err
+	default:
+		return c.power()
+	}
+}
+
+func (c *calculator) power() (float64, error) {
+	base, err := c.factor()
+	if err != nil {
+		return 0, err
+	}
+
+	if c.peek() != '^' {
+		return base, nil
+	}
+	c.pos++
+
+	exponent, err := c.unary()
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+func (c *calculator) factor() (float64, error) {
+	if c.peek() == '(' {
+		c.pos++
+		value, err := c.expr()
+		if err != nil {
+			return 0, err
+		}
+		if c.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis at %d", c.pos)
+		}
+		c.pos++
+		return value, nil
+	}
+
+	c.skipSpaces()
+	start := c.pos
+	for c.pos < len(c.input) && (unicode.IsDigit(c.input[c.pos]) || c.input[c.pos] == '.' || c.input[c.pos] == '_') {
+		c.pos++
+	}
+	if start == c.pos {
+		if c.pos >= len(c.input) {
+			return 0, fmt.Errorf("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected character %q at %d", c.input[c.pos], c.pos)
+	}
+
+	// カンマは小数点 (1,5) と桁区切り (1,500) のどちらの意味か判別できないため受け付けない
+	if c.pos < len(c.input) && c.input[c.pos] == ',' {
+		return 0, fmt.Errorf("unexpected ',' at %d: use '.' as the decimal point and do not use thousands separators", c.pos)
+	}
+
+	// 桁区切りのアンダースコアは無視する
+	var digits []rune
+	for _, r := range c.input[start:c.pos] {
+		if r != '_' {
+			digits = append(digits, r)
+		}
+	}
+
+	value, err := strconv.ParseFloat(string(digits), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", string(c.input[start:c.pos]))
+	}
+	return value, nil
+}
+
+// peek - 空白を読み飛ばして次の文字を返します
+func (c *calculator) peek() rune {
+	c.skipSpaces()
+	if c.pos >= len(c.input) {
+		return 0
+	}
+	return c.input[c.pos]
+}
+
+func (c *calculator) skipSpaces() {
+	for c.pos < len(c.input) && unicode.IsSpace(c.input[c.pos]) {
+		c.pos++
+	}
+}
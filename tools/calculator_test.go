@@ -0,0 +1,59 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       float64
+		wantErr    string
+	}{
+		{name: "precedence", expression: "1 + 2 * 3", want: 7},
+		{name: "parentheses", expression: "(1 + 2) * 3", want: 9},
+		{name: "left associative subtraction", expression: "10 - 4 - 3", want: 3},
+		{name: "left associative division", expression: "100 / 10 / 5", want: 2},
+		{name: "modulo", expression: "17 % 5", want: 2},
+		{name: "power binds tighter than multiplication", expression: "2 * 3 ^ 2", want: 18},
+		{name: "right associative power", expression: "2 ^ 3 ^ 2", want: 512},
+		{name: "unary minus", expression: "-3 + 5", want: 2},
+		{name: "double unary minus", expression: "--3", want: 3},
+		{name: "unary minus in exponent", expression: "2 ^ -1", want: 0.5},
+		{name: "unary minus binds looser than power", expression: "-2 ^ 2", want: -4},
+		{name: "decimal point", expression: "1.5 * 4", want: 6},
+		{name: "underscore separator", expression: "1_000 + 1", want: 1001},
+		{name: "spaces", expression: "  ( 1+2 )*  3 ", want: 9},
+		{name: "division by zero", expression: "1 / 0", wantErr: "division by zero"},
+		{name: "modulo by zero", expression: "1 % (2 - 2)", wantErr: "division by zero"},
+		{name: "thousands separator comma", expression: "1,000 + 1", wantErr: "unexpected ','"},
+		{name: "decimal comma", expression: "1,5 * 2", wantErr: "unexpected ','"},
+		{name: "trailing garbage", expression: "1 + 2 abc", wantErr: "unexpected character 'a'"},
+		{name: "trailing parenthesis", expression: "(1 + 2))", wantErr: "unexpected character ')'"},
+		{name: "missing closing parenthesis", expression: "(1 + 2", wantErr: "missing closing parenthesis"},
+		{name: "unexpected end", expression: "1 +", wantErr: "unexpected end of expression"},
+		{name: "invalid number", expression: "1.2.3", wantErr: "invalid number"},
+		{name: "not finite", expression: "10 ^ 400", wantErr: "not a finite number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.expression)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Evaluate(%q) error = %v, want %q", tt.expression, err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Evaluate(%q) error = %v", tt.expression, err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate(%q) = %v, want %v", tt.expression, got, tt.want)
+			}
+		})
+	}
+}
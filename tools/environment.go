@@ -0,0 +1,27 @@
+package tools
+
+import (
+	"context"
+)
+
+type (
+	// Environment - ツールを呼び出した会話の情報
+	Environment struct {
+		UserID    string
+		ChannelID string
+		ThreadTS  string
+	}
+
+	environmentKey struct{}
+)
+
+// WithEnvironment - ツールから参照できるように会話の情報をコンテキストに設定します
+func WithEnvironment(ctx context.Context, env Environment) context.Context {
+	return context.WithValue(ctx, environmentKey{}, env)
+}
+
+// EnvironmentFrom - コンテキストから会話の情報を取得します
+func EnvironmentFrom(ctx context.Context) (Environment, bool) {
+	env, ok := ctx.Value(environmentKey{}).(Environment)
+	return env, ok
+}
@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"sync"
+)
+
+type (
+	// Handler - ツールの処理。argumentsにはモデルが生成したJSON形式の引数が渡されます
+	Handler func(ctx context.Context, arguments string) (string, error)
+
+	// Tool - モデルから呼び出すことができるツール
+	Tool struct {
+		// Name - ツール名 (英数字・アンダースコア・ハイフンのみ)
+		Name string
+
+		// Description - モデルに提示するツールの説明
+		Description string
+
+		// Parameters - 引数のJSON Schema
+		Parameters json.RawMessage
+
+		// ProgressMessage - ツールの実行中にSlackに表示するメッセージ
+		ProgressMessage string
+
+		Handler Handler
+	}
+
+	// Registry - 利用可能なツールの一覧
+	Registry interface {
+		// Register - ツールを登録します。同じ名前のツールは上書きされます
+		Register(tool Tool)
+
+		// Tools - 登録されているツールを登録順に取得します
+		Tools() []Tool
+
+		// Lookup - 名前からツールを取得します
+		Lookup(name string) (Tool, bool)
+
+		// Call - ツールを実行します
+		Call(ctx context.Context, name string, arguments string) (string, error)
+	}
+
+	registry struct {
+		mu    sync.RWMutex
+		tools []Tool
+	}
+)
+
+func (r *registry) Register(tool Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, t := range r.tools {
+		if t.Name == tool.Name {
+			r.tools[i] = tool
+			return
+		}
+	}
+	r.tools = append(r.tools, tool)
+}
+
+func (r *registry) Tools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]Tool, len(r.tools))
+	copy(tools, r.tools)
+	return tools
+}
+
+func (r *registry) Lookup(name string) (Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, t := range r.tools {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Tool{}, false
+}
+
+func (r *registry) Call(ctx context.Context, name string, arguments string) (string, error) {
+	tool, ok := r.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("unknown tool: %s", name)
+	}
+
+	if arguments == "" {
+		arguments = "{}"
+	}
+
+	return tool.Handler(ctx, arguments)
+}
+
+func NewRegistry() Registry {
+	return &registry{}
+}
+
+var registrySingleton Registry
+
+// ProvideRegistry - 組み込みのツールを登録したRegistryを返します
+func ProvideRegistry(slack slackapi.SlackAPI, log logger.Logger) Registry {
+	if registrySingleton == nil {
+		r := NewRegistry()
+		r.Register(NewCurrentTimeTool(slack, log))
+		r.Register(NewCalculatorTool())
+		r.Register(NewThreadMetadataTool(slack))
+		registrySingleton = r
+	}
+	return registrySingleton
+}
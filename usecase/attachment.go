@@ -0,0 +1,155 @@
+package usecase
+
+import (
+	"fmt"
+	"github.com/SGE-AI/sge-bot/conversation"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxImagesPerConversation - 一つの会話に添付する画像の最大枚数 (新しいメッセージの画像を優先します)
+	MaxImagesPerConversation = 5
+
+	// MaxImageDownloadBytes - ダウンロードする画像ファイルのサイズの上限
+	MaxImageDownloadBytes = 20 * 1024 * 1024
+
+	// MaxTextFileDownloadBytes - ダウンロードするテキストファイルのサイズの上限
+	MaxTextFileDownloadBytes = 2 * 1024 * 1024
+
+	// AttachmentNoteHeader - 一部の添付ファイルを省略した場合に回答の末尾に表示する見出し
+	AttachmentNoteHeader = ":paperclip: 一部の添付ファイルは省略して回答しています"
+)
+
+// textFileExtensions - テキストとして読み込むファイルの拡張子
+var textFileExtensions = map[string]bool{
+	".txt": true, ".log": true, ".md": true, ".csv": true, ".tsv": true,
+	".json": true, ".yaml": true, ".yml": true, ".xml": true, ".toml": true, ".ini": true,
+	".go": true, ".cs": true, ".java": true, ".kt": true, ".swift": true, ".py": true, ".rb": true,
+	".js": true, ".ts": true, ".jsx": true, ".tsx": true, ".php": true, ".rs": true, ".lua": true,
+	".c": true, ".h": true, ".cpp": true, ".hpp": true, ".cc": true, ".m": true, ".mm": true,
+	".sh": true, ".sql": true, ".html": true, ".css": true, ".shader": true, ".hlsl": true, ".cginc": true,
+	".diff": true, ".patch": true, ".proto": true, ".gradle": true, ".tf": true,
+}
+
+// attachFiles - スレッドのメッセージに添付されたファイルを、対応する会話のメッセージに追加します
+// modelが画像に対応していない場合、画像はダウンロードせずに省略した旨の注記だけを追加します
+// 省略・切り詰めたファイルがあれば、回答に表示するための注記を返します
+func (c chat) attachFiles(conv conversation.Conversation, messages []slack.Message, model string) []string {
+	targets := make(map[string]conversation.Message)
+	for _, m := range conv.Messages() {
+		if m.Role() == openai.ChatMessageRoleUser {
+			targets[m.TimeStamp()] = m
+		}
+	}
+
+	var notes []string
+	images := 0
+	vision := conversation.SupportsVision(model)
+	textBudget := c.config.MaxAttachmentTokens()
+	for i := len(messages) - 1; i >= 0; i-- {
+		target, ok := targets[messages[i].Timestamp]
+		if !ok {
+			continue
+		}
+
+		for _, file := range messages[i].Files {
+			switch {
+			case isImageFile(file):
+				if !vision {
+					target.AddPart(conversation.NewImageOmittedPart(file.Name))
+					continue
+				}
+				if images >= MaxImagesPerConversation {
+					notes = append(notes, fmt.Sprintf("%s (画像の枚数が上限を超えたため省略)", file.Name))
+					continue
+				}
+
+				part, err := c.loadImage(file)
+				if err != nil {
+					c.logger.Log(logger.WARN, "failed to load image: %v", err)
+					notes = append(notes, fmt.Sprintf("%s (画像を読み込めなかったため省略)", file.Name))
+					continue
+				}
+
+				target.AddPart(part)
+				images++
+			case isTextFile(file):
+				if textBudget <= 0 {
+					notes = append(notes, fmt.Sprintf("%s (添付ファイル全体のトークン数が上限を超えたため省略)", file.Name))
+					continue
+				}
+
+				part, tokens, truncated, err := c.loadTextFile(file, model, textBudget)
+				if err != nil {
+					c.logger.Log(logger.WARN, "failed to load text file: %v", err)
+					notes = append(notes, fmt.Sprintf("%s (ファイルを読み込めなかったため省略)", file.Name))
+					continue
+				}
+				if truncated {
+					notes = append(notes, fmt.Sprintf("%s (トークン数の上限のため途中まで)", file.Name))
+				}
+
+				target.AddPart(part)
+				textBudget -= tokens
+			}
+		}
+	}
+
+	if len(notes) == 0 {
+		return nil
+	}
+	return []string{AttachmentNoteHeader + ": " + strings.Join(notes, ", ")}
+}
+
+// loadImage - 画像ファイルをダウンロードし、送信できるサイズに縮小します
+func (c chat) loadImage(file slack.File) (conversation.Part, error) {
+	data, err := c.slack.DownloadFile(file, MaxImageDownloadBytes)
+	if err != nil {
+		return conversation.Part{}, err
+	}
+
+	return conversation.NewImagePart(file.Name, data)
+}
+
+// loadTextFile - テキストファイルをダウンロードし、modelで数えたトークン数の上限に収まるよう切り詰めます
+// 一ファイルあたりの上限と、会話全体の残りの上限 (budget) の小さい方が適用されます
+func (c chat) loadTextFile(file slack.File, model string, budget int) (part conversation.Part, tokens int, truncated bool, err error) {
+	data, err := c.slack.DownloadFile(file, MaxTextFileDownloadBytes)
+	if err != nil {
+		return conversation.Part{}, 0, false, err
+	}
+
+	if !utf8.Valid(data) {
+		return conversation.Part{}, 0, false, fmt.Errorf("text file %s is not valid utf-8", file.ID)
+	}
+
+	maxTokens := c.config.MaxFileTokens()
+	if budget < maxTokens {
+		maxTokens = budget
+	}
+
+	content, truncated := conversation.TruncateTokens(model, string(data), maxTokens)
+	return conversation.NewTextFilePart(file.Name, content, truncated), conversation.CountTokens(model, content), truncated, nil
+}
+
+func isImageFile(file slack.File) bool {
+	return strings.HasPrefix(file.Mimetype, "image/")
+}
+
+func isTextFile(file slack.File) bool {
+	if strings.HasPrefix(file.Mimetype, "text/") {
+		return true
+	}
+
+	switch file.Mimetype {
+	case "application/json", "application/xml", "application/javascript", "application/x-yaml", "application/x-sh":
+		return true
+	}
+
+	return textFileExtensions[strings.ToLower(path.Ext(file.Name))]
+}
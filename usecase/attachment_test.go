@@ -0,0 +1,108 @@
+package usecase
+
+import (
+	"bytes"
+	"github.com/SGE-AI/sge-bot/conversation"
+	"github.com/SGE-AI/sge-bot/gpt"
+	"github.com/SGE-AI/sge-bot/tools"
+	"github.com/slack-go/slack"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestAttachFilesImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 16, 16))); err != nil {
+		t.Fatal(err)
+	}
+
+	message := userMessage(testThreadTS, "この画像は？")
+	message.Files = []slack.File{{
+		ID:                 "F0001",
+		Name:               "screenshot.png",
+		Mimetype:           "image/png",
+		Size:               buf.Len(),
+		URLPrivateDownload: "https://files.slack.com/screenshot.png",
+	}}
+
+	tests := []struct {
+		name          string
+		model         string
+		wantDownloads int
+		wantType      conversation.PartType
+	}{
+		{name: "vision model", model: "gpt-4o", wantDownloads: 1, wantType: conversation.PartTypeImage},
+		{name: "text only model", model: "gpt-3.5-turbo", wantDownloads: 0, wantType: conversation.PartTypeText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestChat(newTestConfig(), nil, tools.NewRegistry(), message)
+			api := c.slack.(*fakeSlack)
+			api.files = map[string][]byte{"https://files.slack.com/screenshot.png": buf.Bytes()}
+
+			conv := conversation.NewConversationFromSlackMessages([]slack.Message{message}, testBotUserID)
+			notes := c.attachFiles(conv, []slack.Message{message}, tt.model)
+
+			if len(notes) != 0 {
+				t.Errorf("notes = %v", notes)
+			}
+			if api.downloads != tt.wantDownloads {
+				t.Errorf("downloads = %d, want %d", api.downloads, tt.wantDownloads)
+			}
+
+			parts := conv.Messages()[0].Parts()
+			if len(parts) != 1 || parts[0].Type != tt.wantType || parts[0].Name != "screenshot.png" {
+				t.Fatalf("parts = %+v", parts)
+			}
+		})
+	}
+}
+
+func TestStartConversationQuotaExceededSkipsDownloads(t *testing.T) {
+	message := userMessage(testThreadTS, "このファイルを要約して")
+	message.Files = []slack.File{{
+		ID:                 "F0001",
+		Name:               "notes.txt",
+		Mimetype:           "text/plain",
+		Size:               11,
+		URLPrivateDownload: "https://files.slack.com/notes.txt",
+	}}
+
+	tests := []struct {
+		name          string
+		exceeded      *QuotaExceeded
+		wantDownloads int
+	}{
+		{name: "under quota", wantDownloads: 1},
+		{name: "over quota", exceeded: &QuotaExceeded{}, wantDownloads: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &gpt.FakeClient{Streams: []*gpt.FakeStream{gpt.NewFakeStream("gpt-4o", "summary")}}
+			c, quota := newTestChat(newTestConfig(), client, tools.NewRegistry(), message)
+			quota.exceeded = tt.exceeded
+			api := c.slack.(*fakeSlack)
+			api.files = map[string][]byte{"https://files.slack.com/notes.txt": []byte("hello notes")}
+
+			err := c.startConversation(&fakeBotMessage{}, request{
+				channelID: testChannelID,
+				threadTS:  testThreadTS,
+				userID:    "U0001",
+			})
+			if err != nil {
+				t.Fatalf("startConversation() error = %v", err)
+			}
+			if api.downloads != tt.wantDownloads {
+				t.Errorf("downloads = %d, want %d", api.downloads, tt.wantDownloads)
+			}
+
+			// 添付ファイルを読み込んだ後は、その分を含めて見積もり直す
+			if tt.exceeded == nil && (len(quota.refined) != 1 || quota.refined[0].PromptTokens <= quota.reserved[0].PromptTokens) {
+				t.Errorf("reserved estimate = %v, refined estimate = %v", quota.reserved, quota.refined)
+			}
+		})
+	}
+}
@@ -10,8 +10,11 @@ import (
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/SGE-AI/sge-bot/repository"
 	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/tools"
 	"github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
 	"io"
+	"strings"
 	"time"
 )
 
@@ -23,21 +26,60 @@ const (
 	UpdatingMessage = "..."
 
 	OnErrorMessage = "APIの呼び出しでエラーが発生しました。しばらく時間をおいてから、もう一度お試しください。"
+
+	OnStoppedMessage = "生成を停止しました。"
+
+	InterruptedMessage = ":warning: 回答の生成が途中で中断されました。続きが必要な場合は「再生成」を押してください。"
+
+	// ContinuePrompt - 回答の生成が中断された場合に、続きを生成させるための指示
+	ContinuePrompt = "直前のあなたの回答は通信エラーにより途中で途切れました。途切れた箇所の直後から続きだけを出力してください。既に出力した内容を繰り返したり、前置きを付けたりしないでください。"
+
+	// MaxResumeAttempts - 回答の生成が中断された場合に続きから再開を試みる最大回数
+	MaxResumeAttempts = 2
+
+	// MaxToolRounds - 一回の回答でツールを呼び出せる最大回数
+	MaxToolRounds = 5
+
+	// ToolProgressMessage - ツールの実行中に表示するメッセージ (ツールに指定がない場合)
+	ToolProgressMessage = ":wrench: %s を実行しています…"
+
+	// WaitingForCapacityMessage - APIのレート制限等で再試行を待っている間に表示するメッセージ
+	WaitingForCapacityMessage = ":hourglass_flowing_sand: APIが混雑しているため、空きを待っています... (約%d秒後に再試行します)"
+
+	OnPromptFilteredMessage = "入力された内容がコンテンツフィルターによってブロックされたため、回答できませんでした :bow: 内容を見直して、もう一度お試しください。"
+
+	OnResponseFilteredMessage = ":warning: 回答がコンテンツフィルターによって中断されました。"
+
+	// WaitingForThreadMessage - 同じスレッドで生成中の回答の完了を待っている間に表示するメッセージ
+	WaitingForThreadMessage = ":hourglass: このスレッドの前の回答が終わるのを待っています…"
+
+	// QueuedMessage - 同時実行数の上限に達していて順番待ちをしている間に表示するメッセージ
+	QueuedMessage = ":hourglass: 混み合っているため順番待ちをしています… (%d番目)"
+
+	// DroppedMessagesNote - 会話がコンテキストウィンドウに収まらず、古いメッセージを送信しなかった場合に表示する注記
+	DroppedMessagesNote = ":scissors: スレッドが長いため、古いメッセージ%d件は回答に含めていません"
 )
 
 type (
 	Chat interface {
 		// StartNormalConversation - 通常の会話を開始します
-		StartNormalConversation(channelID string, threadTS string) error
+		StartNormalConversation(channelID string, threadTS string, userID string) error
+
+		// StartPromptConversation - ユーザーの代わりに質問をチャンネルに投稿し、そのスレッドで会話を開始します (スラッシュコマンド向け)
+		StartPromptConversation(channelID string, userID string, prompt string) error
+
+		// AskAboutMessage - 指定したメッセージについての質問をそのスレッドに投稿し、回答を生成します (メッセージショートカット向け)
+		// includeThreadがfalseの場合は、スレッドの他のメッセージを会話に含めず、対象のメッセージと質問だけで回答します
+		AskAboutMessage(channelID string, messageTS string, threadTS string, userID string, prompt string, model string, includeThread bool) error
 
-		// RegenerateMessage - 指定したoutputTSの会話を再生成します
-		RegenerateMessage(channelID string, outputTS string, threadTS string, controllerTS string) error
+		// RegenerateMessage - 指定したoutputTSの会話を再生成します。partTSは回答を構成するすべてのメッセージのタイムスタンプです
+		RegenerateMessage(channelID string, outputTS string, threadTS string, controllerTS string, userID string, partTS []string) error
 
 		// StopGenerateMessage - 指定したoutputTSの会話の生成を停止します
 		StopGenerateMessage(channelID string, outputTS string, controllerTS string) error
 
-		// DeleteMessage - 指定したoutputTSの会話を削除します
-		DeleteMessage(channelID string, outputTS string, controllerTS string) error
+		// DeleteMessage - 指定したoutputTSの会話を、回答を構成するすべてのメッセージ (partTS) と併せて削除します
+		DeleteMessage(channelID string, outputTS string, threadTS string, controllerTS string, partTS []string) error
 	}
 
 	chat struct {
@@ -46,71 +88,152 @@ type (
 		config config.Config
 		logger logger.Logger
 		crepo  repository.ContextCancelRepository
+		srepo  repository.SummaryRepository
+		stat   Statistics
+		quota  Quota
+		sched  Scheduler
+		tools  tools.Registry
+		prefs  Settings
+
+		// threads - 同じスレッドでの回答の生成を一つずつ順番に行うためのロック
+		threads *threadLocks
+	}
+
+	// request - 回答を生成するきっかけとなったリクエストの情報
+	request struct {
+		channelID string
+		threadTS  string
+		userID    string
+
+		// model - 利用するモデル。空の場合は設定されたモデルを利用します
+		model string
+
+		// temperature - 回答のランダム性。nilの場合はモデルの既定値を利用します
+		temperature *float32
+
+		// contextTS - 会話に含めるメッセージのタイムスタンプ。空の場合はスレッド全体を会話に含めます
+		contextTS []string
+
+		// notes - 回答の末尾に表示する注記
+		notes []string
+
+		// usage - 回答のために行ったリクエストの使用量
+		usage *usageMeter
+	}
+
+	// usageMeter - 一回の回答で行った複数のリクエスト (ツールの呼び出しや要約を含む) の使用量を合計します
+	usageMeter struct {
+		total    gpt.UsageRecord
+		requests int
+	}
+
+	// generation - 一回のストリームで生成された内容
+	generation struct {
+		answer    string
+		toolCalls []conversation.ToolCall
+		model     string
+	}
+
+	// toolCallAccumulator - ストリームで分割されて届くツール呼び出しを連結するバッファ
+	toolCallAccumulator struct {
+		calls map[int]*conversation.ToolCall
+		order []int
 	}
 )
 
-func (c chat) StartNormalConversation(channelID string, threadTS string) error {
+// ErrModelNotAllowed - 利用が許可されていないモデルが指定された
+var ErrModelNotAllowed = errors.New("model not allowed")
+
+// ErrCannotPost - ボットがチャンネルに投稿できない (チャンネルに招待されていない、chat:writeの権限がない等)
+var ErrCannotPost = errors.New("cannot post to channel")
+
+// errGenerationStopped - ユーザーの操作によって生成が停止された
+var errGenerationStopped = errors.New("generation stopped")
+
+// errStreamInterrupted - モデルの応答の受信が途中で失敗した (続きから再開できる)
+var errStreamInterrupted = errors.New("stream interrupted")
+
+func (c chat) StartNormalConversation(channelID string, threadTS string, userID string) error {
 	botMessage, err := c.slack.CreateNewBotMessage(channelID, threadTS, AckMessage)
 	if err != nil {
 		return fmt.Errorf("failed to fast post ack message: %v", err)
 	}
 
-	ci, err := c.slack.LoadCustomInstructions(channelID)
+	return c.startConversation(botMessage, request{
+		channelID: channelID,
+		threadTS:  threadTS,
+		userID:    userID,
+	})
+}
+
+func (c chat) StartPromptConversation(channelID string, userID string, prompt string) error {
+	threadTS, err := c.slack.PostPromptMessage(channelID, "", userID, prompt)
 	if err != nil {
-		c.logger.Log(logger.WARN, "failed to load conversation topic: %v", err)
+		return fmt.Errorf("%w: %v", ErrCannotPost, err)
+	}
+
+	return c.StartNormalConversation(channelID, threadTS, userID)
+}
+
+func (c chat) AskAboutMessage(channelID string, messageTS string, threadTS string, userID string, prompt string, model string, includeThread bool) error {
+	if model != "" && !isAllowedModel(c.config, model) {
+		return fmt.Errorf("%w: %s", ErrModelNotAllowed, model)
 	}
 
-	messages, err := c.slack.LoadConversationReplies(channelID, threadTS)
+	// スレッドに属していないメッセージの場合は、そのメッセージをスレッドの親にする
+	if threadTS == "" {
+		threadTS = messageTS
+	}
+
+	promptTS, err := c.slack.PostPromptMessage(channelID, threadTS, userID, prompt)
 	if err != nil {
-		_ = botMessage.UpdateMessage(OnErrorMessage, false)
-		return fmt.Errorf("failed to load conversation replies: %v", err)
+		return fmt.Errorf("%w: %v", ErrCannotPost, err)
 	}
 
-	conv := conversation.NewConversationFromSlackMessages(messages, c.config.BotUserID())
-	conv.SystemMessage(c.config.SystemPrompt(ci))
-	conv.RemoveMessageAfterTimestamp(botMessage.OutputTimeStamp())
+	botMessage, err := c.slack.CreateNewBotMessage(channelID, threadTS, AckMessage)
+	if err != nil {
+		return fmt.Errorf("%w: failed to fast post ack message: %v", ErrCannotPost, err)
+	}
 
-	return c.startConversation(botMessage, conv)
+	req := request{
+		channelID: channelID,
+		threadTS:  threadTS,
+		userID:    userID,
+		model:     model,
+	}
+	if !includeThread {
+		req.contextTS = []string{messageTS, promptTS}
+	}
+	return c.startConversation(botMessage, req)
 }
 
-func (c chat) RegenerateMessage(channelID string, outputTS string, threadTS string, controllerTS string) error {
+func (c chat) RegenerateMessage(channelID string, outputTS string, threadTS string, controllerTS string, userID string, partTS []string) error {
 	cancel, ok := c.crepo.Load(outputTS)
 	if ok {
 		cancel()
 	}
 
-	botMessage, err := c.slack.TakeOverBotMessage(channelID, outputTS, controllerTS)
+	botMessage, err := c.slack.TakeOverBotMessage(channelID, threadTS, outputTS, controllerTS, partTS)
 	if err != nil {
 		return fmt.Errorf("failed to take over bot message: %v", err)
 	}
 
 	botMessage.Regenerate(AckMessage)
 
-	ci, err := c.slack.LoadCustomInstructions(channelID)
-	if err != nil {
-		c.logger.Log(logger.WARN, "failed to load conversation topic: %v", err)
-	}
-
-	messages, err := c.slack.LoadConversationReplies(channelID, threadTS)
-	if err != nil {
-		_ = botMessage.UpdateMessage(OnErrorMessage, false)
-		return fmt.Errorf("failed to load conversation replies: %v", err)
-	}
-
-	conv := conversation.NewConversationFromSlackMessages(messages, c.config.BotUserID())
-	conv.SystemMessage(c.config.SystemPrompt(ci))
-	conv.RemoveMessageAfterTimestamp(botMessage.OutputTimeStamp())
-
-	return c.startConversation(botMessage, conv)
+	return c.startConversation(botMessage, request{
+		channelID: channelID,
+		threadTS:  threadTS,
+		userID:    userID,
+	})
 }
 
-func (c chat) DeleteMessage(channelID string, outputTS string, controllerTS string) error {
+func (c chat) DeleteMessage(channelID string, outputTS string, threadTS string, controllerTS string, partTS []string) error {
 	cancel, ok := c.crepo.Load(outputTS)
 	if ok {
 		cancel()
 	}
 
-	botMessage, err := c.slack.TakeOverBotMessage(channelID, outputTS, controllerTS)
+	botMessage, err := c.slack.TakeOverBotMessage(channelID, threadTS, outputTS, controllerTS, partTS)
 	if err != nil {
 		return fmt.Errorf("failed to take over bot message: %v", err)
 	}
@@ -127,7 +250,35 @@ func (c chat) StopGenerateMessage(channelID string, outputTS string, controllerT
 	return nil
 }
 
-func (c chat) startConversation(botMessage slackapi.BotMessage, conv conversation.Conversation) error {
+// loadConversation - スレッドのメッセージを読み込み、botMessageより前の会話を作成します
+// req.contextTSが指定されている場合は、そのメッセージだけを会話に含めます
+// 添付ファイルはまだ会話に含めず、読み込んだメッセージを併せて返します (attachFilesで追加します)
+func (c chat) loadConversation(req request, botMessage slackapi.BotMessage) (conversation.Conversation, []slack.Message, error) {
+	ci, err := c.slack.LoadCustomInstructions(req.channelID)
+	if err != nil {
+		c.logger.Log(logger.WARN, "failed to load conversation topic: %v", err)
+	}
+
+	messages, err := c.slack.LoadConversationReplies(req.channelID, req.threadTS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load conversation replies: %v", err)
+	}
+	// 順番待ちの間に前の回答の続きが投稿されていても、botMessageより前の会話に含める
+	messages = slackapi.GroupAnswerParts(messages, c.config.BotUserID())
+	if len(req.contextTS) > 0 {
+		messages = filterMessages(messages, req.contextTS)
+	}
+
+	conv := conversation.NewConversationFromSlackMessages(messages, c.config.BotUserID())
+	conv.SystemMessage(c.config.SystemPrompt(ci, c.prefs.Instructions(req.userID)))
+	conv.RemoveMessageAfterTimestamp(botMessage.OutputTimeStamp())
+
+	return conv, messages, nil
+}
+
+// startConversation - スレッドの会話を読み込み、botMessageに回答を生成します
+// 同じスレッドで生成中の回答があれば、その完了を待ってから読み込むため、直前の回答も会話に含まれます
+func (c chat) startConversation(botMessage slackapi.BotMessage, req request) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -135,54 +286,361 @@ func (c chat) startConversation(botMessage slackapi.BotMessage, conv conversatio
 	if err != nil {
 		return fmt.Errorf("failed to save context cancel: %v", err)
 	}
-	defer c.crepo.Delete(botMessage.OutputTimeStamp())
 
-	stream, err := c.gpt.CreateChatCompletionStream(ctx, conv)
+	releaseThread, err := c.threads.acquire(ctx, req.channelID+":"+req.threadTS, func() {
+		_ = botMessage.UpdateMessage(WaitingForThreadMessage, true)
+	})
+	if err != nil {
+		c.crepo.Delete(botMessage.OutputTimeStamp())
+		_ = botMessage.UpdateMessage(OnStoppedMessage, false)
+		return nil
+	}
+	defer func() {
+		c.crepo.Delete(botMessage.OutputTimeStamp())
+		releaseThread()
+	}()
+
+	// 再生成の場合、停止させた前回の生成が終了時に登録を消しているため登録し直す
+	err = c.crepo.Save(botMessage.OutputTimeStamp(), cancel)
+	if err != nil {
+		return fmt.Errorf("failed to save context cancel: %v", err)
+	}
+
+	// モデルが指定されていなければ、ユーザーが個人設定で選んだモデルを利用する
+	if req.model == "" {
+		req.model = c.prefs.Model(req.userID)
+	}
+	req.temperature = c.prefs.Get(req.userID).Temperature
+
+	conv, messages, err := c.loadConversation(req, botMessage)
+	if err != nil {
+		_ = botMessage.UpdateMessage(OnErrorMessage, false)
+		return err
+	}
+
+	ctx = tools.WithEnvironment(ctx, tools.Environment{
+		UserID:    req.userID,
+		ChannelID: req.channelID,
+		ThreadTS:  req.threadTS,
+	})
+
+	// 回答の使用量はまだ分からないため、送信する会話と回答の上限から見積もった分を確保しておく
+	// 上限に達している場合に添付ファイルをダウンロードしないよう、添付ファイルを含めずに見積もってから確認する
+	model := c.requestModel(req)
+	budget := c.promptBudget(model, c.toolDefinitions(model))
+	reservation, exceeded := c.quota.Reserve(req.userID, req.channelID, c.estimateUsage(model, conv, budget))
+	if exceeded != nil {
+		return botMessage.UpdateMessage(exceeded.Message(), false)
+	}
+
+	req.usage = &usageMeter{}
+	defer func() {
+		c.quota.Settle(reservation, req.usage.total)
+		if req.usage.requests > 0 {
+			go c.stat.RecordUsage(req.userID, req.usage.total)
+		}
+	}()
+
+	req.notes = append(req.notes, c.attachFiles(conv, messages, model)...)
+	c.quota.Refine(reservation, c.estimateUsage(model, conv, budget))
+
+	release, err := c.sched.Acquire(ctx, req.userID, queueNotifier(botMessage))
 	if err != nil {
-		errMessage := fmt.Sprintf("%s\n```%s```", OnErrorMessage, err.Error())
-		_ = botMessage.UpdateMessage(errMessage, false)
-		return fmt.Errorf("failed to create chat completion stream: %v", err)
+		_ = botMessage.UpdateMessage(OnStoppedMessage, false)
+		return nil
+	}
+	defer release()
+
+	err = c.compressConversation(ctx, botMessage, conv, req, budget)
+	if errors.Is(err, context.Canceled) {
+		_ = botMessage.UpdateMessage(OnStoppedMessage, false)
+		return nil
+	} else if err != nil {
+		// 要約に失敗した場合は、下で古いメッセージを取り除いて続ける
+		c.logger.Log(logger.WARN, "failed to compress conversation: %v", err)
+	}
+
+	data := ""
+	dropped := 0
+	for round := 0; ; round++ {
+		// ツールの実行結果で会話が伸びるため、リクエストのたびに収める
+		dropped += countTurns(conv.RemoveMessagesToFit(c.requestModel(req), budget))
+
+		// 最後のラウンドではツールを提示せず、必ず回答させる
+		var toolDefinitions []gpt.ToolDefinition
+		if round < MaxToolRounds {
+			toolDefinitions = c.toolDefinitions(c.requestModel(req))
+		}
+
+		gen, err := c.generate(ctx, botMessage, conv, req, data, toolDefinitions)
+		if errors.Is(err, errGenerationStopped) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if round == 0 {
+			go c.stat.RecordModel(req.userID, gen.model)
+		}
+
+		data = joinParagraphs(data, gen.answer)
+		if len(gen.toolCalls) == 0 || ctx.Err() != nil {
+			break
+		}
+
+		conv.AddMessage(conversation.NewToolCallMessage(gen.answer, gen.toolCalls))
+		c.runTools(ctx, botMessage, conv, data, gen.toolCalls)
 	}
 
-	err = c.updateMessageWithChatStream(stream, botMessage)
+	if dropped > 0 {
+		data = joinParagraphs(data, fmt.Sprintf(DroppedMessagesNote, dropped))
+	}
+	for _, note := range req.notes {
+		data = joinParagraphs(data, note)
+	}
+
+	err = botMessage.UpdateMessage(data, false)
 	if err != nil {
-		return fmt.Errorf("failed to update message with chat stream: %v", err)
+		return fmt.Errorf("failed to update message: %v", err)
 	}
 
 	return nil
 }
 
-func (c chat) updateMessageWithChatStream(stream *openai.ChatCompletionStream, message slackapi.BotMessage) error {
+// estimateUsage - 会話を送信して回答を生成した場合の使用量を見積もります (会話はbudgetまでに収めるため、それを超えては数えません)
+func (c chat) estimateUsage(model string, conv conversation.Conversation, budget int) gpt.UsageRecord {
+	promptTokens := conv.Tokens(model)
+	if promptTokens > budget {
+		promptTokens = budget
+	}
+	return gpt.EstimateUsage(model, promptTokens, c.config.CompletionReserveTokens(), c.config.ModelPrices())
+}
+
+// generate - 一回分の回答を生成します。prefixはそれまでに表示した内容で、生成中の内容はその後ろに表示されます
+// 受信が途中で失敗した場合は、生成済みの内容に続けて再開します
+func (c chat) generate(ctx context.Context, botMessage slackapi.BotMessage, conv conversation.Conversation, req request, prefix string, toolDefinitions []gpt.ToolDefinition) (generation, error) {
+	var gen generation
+	for resume := 0; ; resume++ {
+		requestConv := conv
+		if gen.answer != "" {
+			requestConv = continuationOf(conv, gen.answer)
+		}
+
+		opts := []gpt.RequestOption{
+			gpt.WithModel(req.model),
+			gpt.WithTools(toolDefinitions...),
+			gpt.WithUsageHandler(req.usage.add),
+			gpt.WithWaitHandler(func(wait time.Duration) {
+				note := fmt.Sprintf(WaitingForCapacityMessage, int(wait.Seconds()+0.5))
+				_ = botMessage.UpdateMessage(joinParagraphs(joinParagraphs(prefix, gen.answer), note), true)
+			}),
+		}
+		if req.temperature != nil {
+			opts = append(opts, gpt.WithTemperature(*req.temperature))
+		}
+
+		stream, err := c.gpt.CreateChatCompletionStream(ctx, requestConv, opts...)
+		data := joinParagraphs(prefix, gen.answer)
+		if errors.Is(err, context.Canceled) {
+			_ = botMessage.UpdateMessage(joinParagraphs(data, OnStoppedMessage), false)
+			return gen, errGenerationStopped
+		} else if errors.Is(err, gpt.ErrPromptFiltered) {
+			// ツールを実行した後のラウンドでは、それまでに表示した内容を残す
+			_ = botMessage.UpdateMessage(joinParagraphs(prefix, OnPromptFilteredMessage), false)
+			return gen, fmt.Errorf("failed to create chat completion stream: %v", err)
+		} else if gen.answer != "" && err != nil {
+			_ = botMessage.UpdateMessage(joinParagraphs(data, InterruptedMessage), false)
+			return gen, fmt.Errorf("failed to resume chat completion stream: %v", err)
+		} else if err != nil {
+			errMessage := fmt.Sprintf("%s\n```%s```", OnErrorMessage, err.Error())
+			_ = botMessage.UpdateMessage(joinParagraphs(prefix, errMessage), false)
+			return gen, fmt.Errorf("failed to create chat completion stream: %v", err)
+		}
+
+		gen.model = stream.Model()
+		botMessage.SetModel(gen.model)
+
+		gen.answer, gen.toolCalls, err = c.updateMessageWithChatStream(stream, botMessage, prefix, gen.answer)
+		_ = stream.Close()
+		if err == nil {
+			return gen, nil
+		} else if !errors.Is(err, errStreamInterrupted) {
+			// Slackへの投稿に失敗した場合は、生成し直しても表示できないため再開しない
+			return gen, err
+		}
+
+		// 途中まで生成された回答を残したまま、続きから生成し直す (途中までのツール呼び出しは破棄する)
+		gen.toolCalls = nil
+		if resume >= MaxResumeAttempts {
+			_ = botMessage.UpdateMessage(joinParagraphs(joinParagraphs(prefix, gen.answer), InterruptedMessage), false)
+			return gen, fmt.Errorf("failed to update message with chat stream: %v", err)
+		}
+		c.logger.Log(logger.WARN, "stream interrupted, try to resume (%d/%d): %v", resume+1, MaxResumeAttempts, err)
+	}
+}
+
+// updateMessageWithChatStream - ストリームの内容をanswerに追記しながらメッセージを更新し、最終的な内容とツール呼び出しを返します
+// ストリームの受信に失敗した場合は、それまでに受信した内容とerrStreamInterruptedを返します
+// メッセージの更新に失敗した場合は、受信の失敗と区別できるよう別のエラーを返します
+func (c chat) updateMessageWithChatStream(stream gpt.Stream, message slackapi.BotMessage, prefix string, answer string) (string, []conversation.ToolCall, error) {
 	nextUpdate := time.Now().Add(UpdateInterval)
-	data := ""
+	calls := newToolCallAccumulator()
 	for {
-		resp, err := stream.Recv()
+		chunk, err := stream.Recv()
 		if err != nil {
 			if err == io.EOF {
 				break
 			} else if errors.Is(err, context.Canceled) {
 				break
 			} else {
-				return fmt.Errorf("error on stream recv: %v", err)
+				return answer, nil, fmt.Errorf("%w: %v", errStreamInterrupted, err)
 			}
 		}
 
-		data += resp.Choices[0].Delta.Content
-		if time.Now().After(nextUpdate) {
-			err = message.UpdateMessage(data+UpdatingMessage, true)
+		answer += chunk.Content
+		calls.add(chunk.ToolCalls)
+		if chunk.FinishReason == gpt.FinishReasonContentFilter {
+			answer += "\n\n" + OnResponseFilteredMessage
+		}
+		if time.Now().After(nextUpdate) && answer != "" {
+			err = message.UpdateMessage(joinParagraphs(prefix, answer)+UpdatingMessage, true)
 			if err != nil {
-				return fmt.Errorf("failed to update message: %v", err)
+				return answer, nil, fmt.Errorf("failed to update message: %v", err)
 			}
 			nextUpdate = time.Now().Add(UpdateInterval)
 		}
 	}
 
-	err := message.UpdateMessage(data, false)
-	if err != nil {
-		return fmt.Errorf("failed to update message: %v", err)
+	return answer, calls.toolCalls(), nil
+}
+
+// runTools - ツールを実行し、結果を会話に追加します。実行中はSlackに進捗を表示します
+func (c chat) runTools(ctx context.Context, botMessage slackapi.BotMessage, conv conversation.Conversation, data string, calls []conversation.ToolCall) {
+	var progress []string
+	for _, call := range calls {
+		message := fmt.Sprintf(ToolProgressMessage, call.Name)
+		if tool, ok := c.tools.Lookup(call.Name); ok && tool.ProgressMessage != "" {
+			message = tool.ProgressMessage
+		}
+		progress = append(progress, message)
+		_ = botMessage.UpdateMessage(joinParagraphs(data, strings.Join(progress, "\n")), true)
+
+		c.logger.Log(logger.INFO, "call tool: %s", call.Name)
+		result, err := c.tools.Call(ctx, call.Name, call.Arguments)
+		if err != nil {
+			c.logger.Log(logger.WARN, "failed to call tool %s: %v", call.Name, err)
+			result = "error: " + err.Error()
+		}
+
+		conv.AddMessage(conversation.NewToolResultMessage(call.ID, result))
 	}
+}
 
-	return nil
+// toolDefinitions - 登録されているツールをモデルに提示する形式に変換します。ツールに対応していないモデルには提示しません
+func (c chat) toolDefinitions(model string) []gpt.ToolDefinition {
+	if !c.config.ToolsEnabled() || !conversation.SupportsTools(model) {
+		return nil
+	}
+
+	var definitions []gpt.ToolDefinition
+	for _, tool := range c.tools.Tools() {
+		definitions = append(definitions, gpt.ToolDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	return definitions
+}
+
+// requestModel - リクエストで利用するモデルを返します
+func (c chat) requestModel(req request) string {
+	if req.model != "" {
+		return req.model
+	}
+	return c.config.OpenAIModel()
+}
+
+// filterMessages - タイムスタンプが含まれるメッセージだけを取り出します
+func filterMessages(messages []slack.Message, timestamps []string) []slack.Message {
+	var filtered []slack.Message
+	for _, message := range messages {
+		for _, ts := range timestamps {
+			if message.Timestamp == ts {
+				filtered = append(filtered, message)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// queueNotifier - 順番待ちの間、何番目に待っているかをメッセージに表示する関数を作成します
+func queueNotifier(botMessage slackapi.BotMessage) func(ahead int) {
+	last := -1
+	return func(ahead int) {
+		if ahead == last {
+			return
+		}
+		last = ahead
+		_ = botMessage.UpdateMessage(fmt.Sprintf(QueuedMessage, ahead+1), true)
+	}
+}
+
+// continuationOf - 途中まで生成された回答に続けて生成させるための会話を作成します
+func continuationOf(conv conversation.Conversation, partial string) conversation.Conversation {
+	continuation := conv.Clone()
+	continuation.AddMessage(conversation.NewMessage(openai.ChatMessageRoleAssistant, partial, "", ""))
+	continuation.AddMessage(conversation.NewMessage(openai.ChatMessageRoleUser, ContinuePrompt, "", ""))
+	return continuation
+}
+
+func (m *usageMeter) add(usage gpt.UsageRecord) {
+	m.total = m.total.Add(usage)
+	m.requests++
+}
+
+// joinParagraphs - 空でない文章を空行で区切って連結します
+func joinParagraphs(first string, second string) string {
+	if first == "" {
+		return second
+	}
+	if second == "" {
+		return first
+	}
+	return first + "\n\n" + second
+}
+
+// newToolCallAccumulator - ストリームで分割されて届くツール呼び出しを連結するためのバッファを作成します
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{
+		calls: make(map[int]*conversation.ToolCall),
+	}
+}
+
+func (a *toolCallAccumulator) add(deltas []gpt.ToolCallDelta) {
+	for _, delta := range deltas {
+		call, ok := a.calls[delta.Index]
+		if !ok {
+			call = &conversation.ToolCall{}
+			a.calls[delta.Index] = call
+			a.order = append(a.order, delta.Index)
+		}
+
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		call.Name += delta.Name
+		call.Arguments += delta.Arguments
+	}
+}
+
+func (a *toolCallAccumulator) toolCalls() []conversation.ToolCall {
+	var calls []conversation.ToolCall
+	for _, index := range a.order {
+		calls = append(calls, *a.calls[index])
+	}
+	return calls
 }
 
 func ProvideChat(
@@ -191,6 +649,12 @@ func ProvideChat(
 	logger logger.Logger,
 	api slackapi.SlackAPI,
 	crepo repository.ContextCancelRepository,
+	srepo repository.SummaryRepository,
+	stat Statistics,
+	quota Quota,
+	sched Scheduler,
+	tools tools.Registry,
+	prefs Settings,
 ) Chat {
 	return &chat{
 		slack:  api,
@@ -198,5 +662,13 @@ func ProvideChat(
 		config: config,
 		logger: logger,
 		crepo:  crepo,
+		srepo:  srepo,
+		stat:   stat,
+		quota:  quota,
+		sched:  sched,
+		tools:  tools,
+		prefs:  prefs,
+
+		threads: newThreadLocks(),
 	}
 }
@@ -0,0 +1,500 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/config"
+	"github.com/SGE-AI/sge-bot/gpt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/tools"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"github.com/slack-go/slack"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testBotUserID = "UBOT"
+	testChannelID = "C0001"
+	testThreadTS  = "1700000000.000100"
+	testOutputTS  = "1700000000.000300"
+)
+
+type (
+	// byteBpeLoader - 1バイトを1トークンとして扱うエンコーディング (テストではエンコーディングをダウンロードしない)
+	byteBpeLoader struct{}
+
+	fakeConfig struct {
+		config.Config
+		model          string
+		fallbackModels []string
+		maxPrompt      int
+		reserve        int
+		windows        map[string]int
+		toolsEnabled   bool
+	}
+
+	fakeSlack struct {
+		slackapi.SlackAPI
+		messages []slack.Message
+
+		// files - URLごとのダウンロードできるファイルの内容
+		files     map[string][]byte
+		downloads int
+	}
+
+	fakeBotMessage struct {
+		mu       sync.Mutex
+		updates  []string
+		final    string
+		finished bool
+		model    string
+	}
+
+	fakeQuota struct {
+		Quota
+		mu       sync.Mutex
+		exceeded *QuotaExceeded
+		reserved []gpt.UsageRecord
+		refined  []gpt.UsageRecord
+		recorded []gpt.UsageRecord
+	}
+
+	fakeScheduler struct{}
+
+	fakeStatistics struct {
+		Statistics
+	}
+
+	fakeSettings struct {
+		Settings
+	}
+
+	nopLogger struct{}
+)
+
+func TestMain(m *testing.M) {
+	tiktoken.SetBpeLoader(byteBpeLoader{})
+	os.Exit(m.Run())
+}
+
+func (byteBpeLoader) LoadTiktokenBpe(string) (map[string]int, error) {
+	ranks := make(map[string]int, 256)
+	for i := 0; i < 256; i++ {
+		ranks[string([]byte{byte(i)})] = i
+	}
+	return ranks, nil
+}
+
+func (c *fakeConfig) BotUserID() string                         { return testBotUserID }
+func (c *fakeConfig) OpenAIModel() string                       { return c.model }
+func (c *fakeConfig) OpenAIFallbackModels() []string            { return c.fallbackModels }
+func (c *fakeConfig) OpenAISummaryModel() string                { return c.model }
+func (c *fakeConfig) MaxPromptTokens() int                      { return c.maxPrompt }
+func (c *fakeConfig) CompletionReserveTokens() int              { return c.reserve }
+func (c *fakeConfig) ModelContextWindows() map[string]int       { return c.windows }
+func (c *fakeConfig) ToolsEnabled() bool                        { return c.toolsEnabled }
+func (c *fakeConfig) ModelPrices() map[string]config.ModelPrice { return nil }
+func (c *fakeConfig) MaxFileTokens() int                        { return 1000 }
+func (c *fakeConfig) MaxAttachmentTokens() int                  { return 1000 }
+func (c *fakeConfig) SystemPrompt(customInstructions string, userInstructions string) string {
+	return "You are a helpful assistant."
+}
+
+func (s *fakeSlack) LoadCustomInstructions(channelId string) (string, error) {
+	return "", nil
+}
+
+func (s *fakeSlack) LoadConversationReplies(channelId string, timeStamp string) ([]slack.Message, error) {
+	return s.messages, nil
+}
+
+func (s *fakeSlack) DownloadFile(file slack.File, maxBytes int) ([]byte, error) {
+	s.downloads++
+	data, ok := s.files[file.URLPrivateDownload]
+	if !ok {
+		return nil, fmt.Errorf("file %s is not found", file.ID)
+	}
+	return data, nil
+}
+
+func (b *fakeBotMessage) UpdateMessage(message string, isUpdating bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.updates = append(b.updates, message)
+	if !isUpdating {
+		b.final = message
+		b.finished = true
+	}
+	return nil
+}
+
+func (b *fakeBotMessage) Regenerate(initialMessage string) {}
+
+func (b *fakeBotMessage) OutputTimeStamp() string { return testOutputTS }
+
+func (b *fakeBotMessage) DeleteMySelf() error { return nil }
+
+func (b *fakeBotMessage) SetModel(model string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.model = model
+}
+
+func (q *fakeQuota) Reserve(userID string, channelID string, estimate gpt.UsageRecord) (*QuotaReservation, *QuotaExceeded) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved = append(q.reserved, estimate)
+	if q.exceeded != nil {
+		return nil, q.exceeded
+	}
+	return &QuotaReservation{}, nil
+}
+
+func (q *fakeQuota) Refine(reservation *QuotaReservation, estimate gpt.UsageRecord) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.refined = append(q.refined, estimate)
+}
+
+func (q *fakeQuota) Settle(reservation *QuotaReservation, usage gpt.UsageRecord) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.recorded = append(q.recorded, usage)
+}
+
+func (fakeScheduler) Acquire(ctx context.Context, userID string, onWait func(ahead int)) (func(), error) {
+	return func() {}, nil
+}
+
+func (fakeStatistics) RecordModel(SlackUserID string, model string) {}
+
+func (fakeStatistics) RecordUsage(SlackUserID string, usage gpt.UsageRecord) {}
+
+func (fakeSettings) Get(userID string) repository.UserSettings { return repository.UserSettings{} }
+
+func (fakeSettings) Model(userID string) string { return "" }
+
+func (fakeSettings) Instructions(userID string) string { return "" }
+
+func (nopLogger) Log(level logger.LogLevel, format string, args ...interface{}) {}
+
+func newTestConfig() *fakeConfig {
+	return &fakeConfig{
+		model:     "gpt-4o",
+		maxPrompt: 100000,
+		reserve:   4000,
+	}
+}
+
+// newTestChat - 偽のSlack・OpenAIと組み合わせたchatを作成します
+func newTestChat(cfg *fakeConfig, client gpt.Client, registry tools.Registry, messages ...slack.Message) (chat, *fakeQuota) {
+	quota := &fakeQuota{}
+	return chat{
+		slack:   &fakeSlack{messages: messages},
+		gpt:     client,
+		config:  cfg,
+		logger:  nopLogger{},
+		crepo:   repository.NewInMemoryContextRepository(),
+		srepo:   repository.NewInMemorySummaryRepository(),
+		stat:    fakeStatistics{},
+		quota:   quota,
+		sched:   fakeScheduler{},
+		tools:   registry,
+		prefs:   fakeSettings{},
+		threads: newThreadLocks(),
+	}, quota
+}
+
+func userMessage(ts string, text string) slack.Message {
+	message := slack.Message{}
+	message.User = "U0001"
+	message.Timestamp = ts
+	message.Text = text
+	return message
+}
+
+func TestStartConversation(t *testing.T) {
+	echo := tools.Tool{
+		Name:       "echo",
+		Parameters: json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}}}`),
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			var args struct{ Text string }
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", err
+			}
+			return "echo: " + args.Text, nil
+		},
+	}
+
+	interrupted := gpt.NewFakeStream("gpt-4o", "Hello")
+	interrupted.Err = errors.New("unexpected EOF")
+
+	toolCall := &gpt.FakeStream{
+		ModelName: "gpt-4o",
+		Chunks: []gpt.Chunk{
+			{ToolCalls: []gpt.ToolCallDelta{{Index: 0, ID: "call_1", Name: "echo", Arguments: `{"text":`}}},
+			{ToolCalls: []gpt.ToolCallDelta{{Index: 0, Arguments: `"hi"}`}}, FinishReason: gpt.FinishReasonToolCalls},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		streams      []*gpt.FakeStream
+		toolsEnabled bool
+		wantAnswer   string
+		wantModel    string
+		wantRequests int
+		check        func(t *testing.T, client *gpt.FakeClient)
+	}{
+		{
+			name:         "normal answer",
+			streams:      []*gpt.FakeStream{gpt.NewFakeStream("gpt-4o", "Hello", ", world")},
+			wantAnswer:   "Hello, world",
+			wantModel:    "gpt-4o",
+			wantRequests: 1,
+			check: func(t *testing.T, client *gpt.FakeClient) {
+				messages := client.Request(0).Conversation.Messages()
+				if len(messages) != 1 || !strings.HasPrefix(messages[0].Content(), "question") {
+					t.Errorf("unexpected conversation: %d messages", len(messages))
+				}
+			},
+		},
+		{
+			name:         "resume after mid-stream error",
+			streams:      []*gpt.FakeStream{interrupted, gpt.NewFakeStream("gpt-4o", ", world")},
+			wantAnswer:   "Hello, world",
+			wantModel:    "gpt-4o",
+			wantRequests: 2,
+			check: func(t *testing.T, client *gpt.FakeClient) {
+				if !interrupted.Closed() {
+					t.Errorf("interrupted stream is not closed")
+				}
+
+				messages := client.Request(1).Conversation.Messages()
+				if len(messages) < 2 {
+					t.Fatalf("continuation has %d messages", len(messages))
+				}
+				partial, prompt := messages[len(messages)-2], messages[len(messages)-1]
+				if partial.Role() != openai.ChatMessageRoleAssistant || partial.Content() != "Hello" {
+					t.Errorf("partial answer = %s %q", partial.Role(), partial.Content())
+				}
+				if prompt.Role() != openai.ChatMessageRoleUser || prompt.Content() != ContinuePrompt {
+					t.Errorf("continue prompt = %s %q", prompt.Role(), prompt.Content())
+				}
+			},
+		},
+		{
+			name:         "tool call round",
+			streams:      []*gpt.FakeStream{toolCall, gpt.NewFakeStream("gpt-4o", "done")},
+			toolsEnabled: true,
+			wantAnswer:   "done",
+			wantModel:    "gpt-4o",
+			wantRequests: 2,
+			check: func(t *testing.T, client *gpt.FakeClient) {
+				if tools := client.Request(0).Tools; len(tools) != 1 || tools[0].Name != "echo" {
+					t.Errorf("tools = %v", tools)
+				}
+
+				messages := client.Request(1).Conversation.Messages()
+				if len(messages) < 2 {
+					t.Fatalf("second request has %d messages", len(messages))
+				}
+				call, result := messages[len(messages)-2], messages[len(messages)-1]
+				if calls := call.ToolCalls(); len(calls) != 1 || calls[0].ID != "call_1" || calls[0].Arguments != `{"text":"hi"}` {
+					t.Errorf("tool calls = %v", calls)
+				}
+				if result.Role() != openai.ChatMessageRoleTool || result.ToolCallID() != "call_1" || result.Content() != "echo: hi" {
+					t.Errorf("tool result = %s %s %q", result.Role(), result.ToolCallID(), result.Content())
+				}
+			},
+		},
+		{
+			name:         "fallback model is reported",
+			streams:      []*gpt.FakeStream{gpt.NewFakeStream("gpt-4o-mini", "fallback answer")},
+			wantAnswer:   "fallback answer",
+			wantModel:    "gpt-4o-mini",
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.fallbackModels = []string{"gpt-4o-mini"}
+			cfg.toolsEnabled = tt.toolsEnabled
+
+			registry := tools.NewRegistry()
+			registry.Register(echo)
+
+			client := &gpt.FakeClient{Streams: tt.streams}
+			c, quota := newTestChat(cfg, client, registry, userMessage(testThreadTS, "question"))
+			botMessage := &fakeBotMessage{}
+
+			err := c.startConversation(botMessage, request{
+				channelID: testChannelID,
+				threadTS:  testThreadTS,
+				userID:    "U0001",
+			})
+			if err != nil {
+				t.Fatalf("startConversation() error = %v", err)
+			}
+
+			if !botMessage.finished || botMessage.final != tt.wantAnswer {
+				t.Errorf("answer = %q, want %q", botMessage.final, tt.wantAnswer)
+			}
+			if botMessage.model != tt.wantModel {
+				t.Errorf("model = %q, want %q", botMessage.model, tt.wantModel)
+			}
+			if len(client.Requests) != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", len(client.Requests), tt.wantRequests)
+			}
+			if len(quota.recorded) != 1 || quota.recorded[0].Model != tt.wantModel {
+				t.Errorf("recorded usage = %v", quota.recorded)
+			}
+			if tt.check != nil {
+				tt.check(t, client)
+			}
+		})
+	}
+}
+
+func TestStartConversationStreamError(t *testing.T) {
+	echo := tools.Tool{
+		Name: "echo",
+		Handler: func(ctx context.Context, arguments string) (string, error) {
+			return "echo", nil
+		},
+	}
+	toolRound := func() *gpt.FakeStream {
+		return &gpt.FakeStream{
+			ModelName: "gpt-4o",
+			Chunks: []gpt.Chunk{
+				{Content: "Let me check."},
+				{ToolCalls: []gpt.ToolCallDelta{{Index: 0, ID: "call_1", Name: "echo", Arguments: `{}`}}, FinishReason: gpt.FinishReasonToolCalls},
+			},
+		}
+	}
+	filtered := fmt.Errorf("%w: content_filter", gpt.ErrPromptFiltered)
+
+	tests := []struct {
+		name         string
+		streams      []*gpt.FakeStream
+		streamErrors []error
+		wantPrefix   string
+		wantContains string
+		wantErr      string
+	}{
+		{
+			name:         "first request",
+			streamErrors: []error{errors.New("invalid api key")},
+			wantPrefix:   OnErrorMessage,
+			wantContains: "invalid api key",
+			wantErr:      "failed to create chat completion stream",
+		},
+		{
+			name:         "filtered after a tool round",
+			streams:      []*gpt.FakeStream{toolRound()},
+			streamErrors: []error{nil, filtered},
+			wantPrefix:   "Let me check.\n\n" + OnPromptFilteredMessage,
+			wantErr:      "failed to create chat completion stream",
+		},
+		{
+			name:         "error after a tool round",
+			streams:      []*gpt.FakeStream{toolRound()},
+			streamErrors: []error{nil, errors.New("invalid request")},
+			wantPrefix:   "Let me check.\n\n" + OnErrorMessage,
+			wantContains: "invalid request",
+			wantErr:      "failed to create chat completion stream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.toolsEnabled = true
+
+			registry := tools.NewRegistry()
+			registry.Register(echo)
+
+			client := &gpt.FakeClient{Streams: tt.streams, StreamErrors: tt.streamErrors}
+			c, _ := newTestChat(cfg, client, registry, userMessage(testThreadTS, "question"))
+			botMessage := &fakeBotMessage{}
+
+			err := c.startConversation(botMessage, request{
+				channelID: testChannelID,
+				threadTS:  testThreadTS,
+				userID:    "U0001",
+			})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("startConversation() error = %v, want %q", err, tt.wantErr)
+			}
+			if !strings.HasPrefix(botMessage.final, tt.wantPrefix) || !strings.Contains(botMessage.final, tt.wantContains) {
+				t.Errorf("error message = %q", botMessage.final)
+			}
+			if strings.Contains(botMessage.final, InterruptedMessage) {
+				t.Errorf("error is shown as interrupted: %q", botMessage.final)
+			}
+		})
+	}
+}
+
+func botMessageAt(ts string, text string) slack.Message {
+	message := slack.Message{}
+	message.User = testBotUserID
+	message.Timestamp = ts
+	message.Text = text
+	return message
+}
+
+func TestStartConversationAfterSplitAnswer(t *testing.T) {
+	// 前の回答 (000150) の生成中に次の質問 (000200) があり、その回答 (testOutputTS) が順番待ちをしている間に
+	// 前の回答の続き (000400) とコントローラー (000500) が投稿された
+	controller := botMessageAt("1700000000.000500", "")
+	controller.Blocks = slack.Blocks{BlockSet: []slack.Block{slack.NewActionBlock(
+		"1700000000.000150",
+		slack.NewButtonBlockElement("regenerate", "1700000000.000150,1700000000.000400", slack.NewTextBlockObject(slack.PlainTextType, "再生成", false, false)),
+	)}}
+	messages := []slack.Message{
+		userMessage(testThreadTS, "first question"),
+		botMessageAt("1700000000.000150", "first part"),
+		userMessage("1700000000.000200", "second question"),
+		botMessageAt(testOutputTS, WaitingForThreadMessage),
+		botMessageAt("1700000000.000400", "second part"),
+		controller,
+	}
+
+	client := &gpt.FakeClient{Streams: []*gpt.FakeStream{gpt.NewFakeStream("gpt-4o", "answer")}}
+	c, _ := newTestChat(newTestConfig(), client, tools.NewRegistry(), messages...)
+
+	err := c.startConversation(&fakeBotMessage{}, request{
+		channelID: testChannelID,
+		threadTS:  testThreadTS,
+		userID:    "U0001",
+	})
+	if err != nil {
+		t.Fatalf("startConversation() error = %v", err)
+	}
+
+	var got []string
+	for _, m := range client.Request(0).Conversation.Messages() {
+		got = append(got, m.Role()+": "+strings.SplitN(m.Content(), " (UserID", 2)[0])
+	}
+	want := []string{
+		"user: first question",
+		"assistant: first part",
+		"assistant: second part",
+		"user: second question",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("conversation = %q, want %q", got, want)
+	}
+}
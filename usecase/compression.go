@@ -0,0 +1,220 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/conversation"
+	"github.com/SGE-AI/sge-bot/gpt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+)
+
+const (
+	// KeepRecentMessages - 要約せずにそのまま送信する直近のメッセージ数
+	KeepRecentMessages = 6
+
+	// SummaryReserveTokens - 要約を会話に含めるために確保しておくトークン数
+	SummaryReserveTokens = 1000
+
+	// MaxSummaryMessageTokens - 要約の入力に含める一メッセージあたりのトークン数の上限
+	MaxSummaryMessageTokens = 1000
+
+	SummarizingMessage = ":memo: スレッドが長くなったため、過去の会話を要約しています…"
+
+	// SummaryPrompt - 古い会話を要約させるための指示
+	SummaryPrompt = "あなたはSlackのスレッドでの会話を要約するアシスタントです。" +
+		"これまでの要約と新しいメッセージをもとに、会話の続きに必要な情報 (前提条件、決定事項、ユーザーの要望、未解決の質問、固有名詞や数値) を漏らさずに、日本語の箇条書きで簡潔にまとめてください。" +
+		"要約以外の内容は出力しないでください。"
+)
+
+// promptBudget - 指定したモデル、設定されたモデル、フォールバック先のモデルのいずれにも送信できる会話のトークン数を求めます
+// コンテキストウィンドウから回答の生成に必要なトークン数と、併せて送信するツールの定義の分を差し引き、MaxPromptTokensを上限とします
+func (c chat) promptBudget(model string, toolDefinitions []gpt.ToolDefinition) int {
+	var toolJSON string
+	if len(toolDefinitions) > 0 {
+		data, err := json.Marshal(toolDefinitions)
+		if err != nil {
+			c.logger.Log(logger.WARN, "failed to marshal tool definitions: %v", err)
+		}
+		toolJSON = string(data)
+	}
+
+	budget := c.config.MaxPromptTokens()
+	models := append([]string{model, c.config.OpenAIModel()}, c.config.OpenAIFallbackModels()...)
+	for _, model := range models {
+		window := conversation.ContextWindow(model, c.config.ModelContextWindows())
+
+		// 予約分がウィンドウの大半を占める小さなモデルでも、会話を送れるようにする
+		reserve := c.config.CompletionReserveTokens()
+		if reserve > window/2 {
+			reserve = window / 2
+		}
+
+		available := window - reserve
+		if limit := c.config.MaxPromptTokens(); limit < available {
+			available = limit
+		}
+		available -= conversation.CountTokens(model, toolJSON)
+
+		if available < budget {
+			budget = available
+		}
+	}
+	return budget
+}
+
+// countTurns - 取り除いたメッセージのうち、ユーザーの発言とアシスタントの回答の数を数えます
+// ツールの呼び出しとその実行結果は、回答の途中経過のため数えません
+func countTurns(messages []conversation.Message) int {
+	turns := 0
+	for _, m := range messages {
+		switch m.Role() {
+		case openai.ChatMessageRoleUser:
+			turns++
+		case openai.ChatMessageRoleAssistant:
+			if len(m.ToolCalls()) == 0 {
+				turns++
+			}
+		}
+	}
+	return turns
+}
+
+// compressConversation - 会話のトークン数がbudgetを超える場合、古いメッセージを要約に置き換えます
+// 要約はスレッドごとに保存され、次回以降は新しく溢れたメッセージだけを追加で要約します
+func (c chat) compressConversation(ctx context.Context, botMessage slackapi.BotMessage, conv conversation.Conversation, req request, budget int) error {
+	model := c.requestModel(req)
+	if conv.Tokens(model) <= budget {
+		return nil
+	}
+
+	count := foldCount(conv, model, budget-SummaryReserveTokens)
+	if count == 0 {
+		return nil
+	}
+	folded := conv.Messages()[:count]
+	until := folded[count-1].TimeStamp()
+
+	// 一部のメッセージだけで会話する場合 (メッセージショートカット等) は、スレッド全体の要約と混ざらないよう保存しない
+	threadKey := req.channelID + ":" + req.threadTS
+	cacheable := len(req.contextTS) == 0
+
+	var summary repository.Summary
+	ok := false
+	if cacheable {
+		summary, ok = c.srepo.Load(threadKey)
+	}
+	if !ok || summary.UntilTimeStamp != until {
+		// 保存されている要約の続きから、新しく溢れたメッセージだけを要約する
+		previous := ""
+		targets := folded
+		if ok {
+			for i, m := range folded {
+				if m.TimeStamp() == summary.UntilTimeStamp {
+					previous = summary.Text
+					targets = folded[i+1:]
+					break
+				}
+			}
+		}
+
+		_ = botMessage.UpdateMessage(SummarizingMessage, true)
+		c.logger.Log(logger.INFO, "summarize %d messages in thread %s", len(targets), threadKey)
+
+		text, err := c.summarize(ctx, previous, targets, req.usage)
+		if err != nil {
+			return fmt.Errorf("failed to summarize conversation: %v", err)
+		}
+
+		summary = repository.Summary{UntilTimeStamp: until, Text: text}
+		if cacheable {
+			c.srepo.Save(threadKey, summary)
+		}
+	}
+
+	conv.RemoveOldestMessages(count)
+	conv.SetSummary(summary.Text)
+	return nil
+}
+
+// foldCount - 直近のメッセージを残したまま、会話をbudgetに収めるために要約に回すメッセージ数を求めます
+func foldCount(conv conversation.Conversation, model string, budget int) int {
+	total := conv.Tokens(model)
+	counts := conv.MessageTokens(model)
+
+	count := 0
+	for len(counts)-count > KeepRecentMessages && total > budget {
+		total -= counts[count]
+		count++
+	}
+	return count
+}
+
+// summarize - これまでの要約にmessagesの内容を加えた要約を作成します
+// 一度に要約モデルのコンテキストウィンドウに収まらない場合は、収まる分ずつ順に要約に加えていきます
+func (c chat) summarize(ctx context.Context, previous string, messages []conversation.Message, usage *usageMeter) (string, error) {
+	model := c.config.OpenAISummaryModel()
+	budget := c.promptBudget(model, nil)
+
+	// 会話を毎回組み立て直して数えると長いスレッドで時間がかかるため、メッセージごとのトークン数を足していく
+	summary := previous
+	var chunk []string
+	total := newSummaryConversation(summary, nil).Tokens(model)
+	for _, m := range messages {
+		entry := summaryEntry(model, m)
+		tokens := conversation.CountTokens(model, "\n\n"+entry)
+		if len(chunk) > 0 && total+tokens > budget {
+			text, err := c.summarizeChunk(ctx, model, summary, chunk, usage)
+			if err != nil {
+				return "", err
+			}
+			summary, chunk = text, nil
+			total = newSummaryConversation(summary, nil).Tokens(model)
+		}
+		chunk = append(chunk, entry)
+		total += tokens
+	}
+
+	if len(chunk) == 0 {
+		return summary, nil
+	}
+	return c.summarizeChunk(ctx, model, summary, chunk, usage)
+}
+
+// summarizeChunk - 一回のリクエストで、これまでの要約にtranscriptの内容を加えた要約を作成します
+func (c chat) summarizeChunk(ctx context.Context, model string, previous string, transcript []string, usage *usageMeter) (string, error) {
+	text, err := c.gpt.CreateChatCompletion(ctx, newSummaryConversation(previous, transcript), gpt.WithModel(model), gpt.WithUsageHandler(usage.add))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// summaryEntry - メッセージを要約の入力に含める一行の形式に変換します
+func summaryEntry(model string, m conversation.Message) string {
+	content, _ := conversation.TruncateTokens(model, m.Content(), MaxSummaryMessageTokens)
+	for _, part := range m.Parts() {
+		if part.Name != "" {
+			content += fmt.Sprintf("\n(添付: %s)", part.Name)
+		}
+	}
+	return fmt.Sprintf("[%s] %s", m.Role(), content)
+}
+
+// newSummaryConversation - これまでの要約と新しいメッセージから、要約を依頼する会話を作成します
+func newSummaryConversation(previous string, transcript []string) conversation.Conversation {
+	input := "新しいメッセージ:\n" + strings.Join(transcript, "\n\n")
+	if previous != "" {
+		input = "これまでの要約:\n" + previous + "\n\n" + input
+	}
+
+	summaryConv := conversation.NewConversation([]conversation.Message{
+		conversation.NewMessage(openai.ChatMessageRoleUser, input, "", ""),
+	})
+	summaryConv.SystemMessage(SummaryPrompt)
+	return summaryConv
+}
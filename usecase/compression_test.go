@@ -0,0 +1,185 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/conversation"
+	"github.com/SGE-AI/sge-bot/gpt"
+	"github.com/SGE-AI/sge-bot/tools"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+	"testing"
+)
+
+// longMessages - 1バイトを1トークンとして数えるとsizeトークンになるメッセージを、ユーザーとアシスタント交互にcount件作成します
+func longMessages(count int, size int) []conversation.Message {
+	var messages []conversation.Message
+	for i := 0; i < count; i++ {
+		role := openai.ChatMessageRoleUser
+		if i%2 == 1 {
+			role = openai.ChatMessageRoleAssistant
+		}
+		ts := fmt.Sprintf("1700000000.%06d", i+1)
+		messages = append(messages, conversation.NewMessage(role, strings.Repeat("a", size), "", ts))
+	}
+	return messages
+}
+
+func TestSummarizeChunks(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.windows = map[string]int{"gpt-4o": 3000}
+	cfg.reserve = 1000
+
+	client := &gpt.FakeClient{Completion: "summary"}
+	c, _ := newTestChat(cfg, client, tools.NewRegistry())
+	budget := c.promptBudget(cfg.OpenAISummaryModel(), nil)
+
+	summary, err := c.summarize(context.Background(), "", longMessages(10, 600), &usageMeter{})
+	if err != nil {
+		t.Fatalf("summarize() error = %v", err)
+	}
+	if summary != "summary" {
+		t.Errorf("summary = %q", summary)
+	}
+
+	if len(client.Requests) < 2 {
+		t.Fatalf("requests = %d, want the messages split into several requests", len(client.Requests))
+	}
+	for i, req := range client.Requests {
+		if tokens := req.Conversation.Tokens(req.Model); tokens > budget {
+			t.Errorf("request %d has %d tokens, budget %d", i, tokens, budget)
+		}
+
+		input := req.Conversation.Messages()[0].Content()
+		if hasPrevious := strings.HasPrefix(input, "これまでの要約:\nsummary"); hasPrevious != (i > 0) {
+			t.Errorf("request %d does not continue from the previous summary: %q", i, input[:40])
+		}
+	}
+}
+
+func TestCompressConversationCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		contextTS []string
+		wantSaved bool
+	}{
+		{name: "whole thread", wantSaved: true},
+		{name: "selected messages", contextTS: []string{"1700000000.000001", "1700000000.000010"}, wantSaved: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &gpt.FakeClient{Completion: "summary"}
+			c, _ := newTestChat(newTestConfig(), client, tools.NewRegistry())
+			conv := conversation.NewConversation(longMessages(10, 500))
+			req := request{
+				channelID: testChannelID,
+				threadTS:  testThreadTS,
+				contextTS: tt.contextTS,
+				usage:     &usageMeter{},
+			}
+
+			err := c.compressConversation(context.Background(), &fakeBotMessage{}, conv, req, 3000)
+			if err != nil {
+				t.Fatalf("compressConversation() error = %v", err)
+			}
+			if len(client.Requests) != 1 {
+				t.Errorf("requests = %d, want 1", len(client.Requests))
+			}
+
+			_, saved := c.srepo.Load(testChannelID + ":" + testThreadTS)
+			if saved != tt.wantSaved {
+				t.Errorf("saved = %t, want %t", saved, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestPromptBudget(t *testing.T) {
+	toolDefinitions := []gpt.ToolDefinition{{
+		Name:        "calculate",
+		Description: "Evaluates an arithmetic expression.",
+		Parameters:  []byte(`{"type":"object","properties":{"expression":{"type":"string"}}}`),
+	}}
+	toolJSON, err := json.Marshal(toolDefinitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toolTokens := conversation.CountTokens("gpt-4o", string(toolJSON))
+
+	tests := []struct {
+		name            string
+		model           string
+		fallbackModels  []string
+		windows         map[string]int
+		maxPrompt       int
+		reserve         int
+		toolDefinitions []gpt.ToolDefinition
+		want            int
+	}{
+		{
+			name:      "context window minus reserve",
+			model:     "gpt-4o",
+			windows:   map[string]int{"gpt-4o": 8000},
+			maxPrompt: 100000,
+			reserve:   3000,
+			want:      5000,
+		},
+		{
+			name:      "max prompt tokens",
+			model:     "gpt-4o",
+			windows:   map[string]int{"gpt-4o": 8000},
+			maxPrompt: 1000,
+			reserve:   3000,
+			want:      1000,
+		},
+		{
+			name:           "smallest fallback model",
+			model:          "gpt-4o",
+			fallbackModels: []string{"local-model"},
+			windows:        map[string]int{"gpt-4o": 8000, "local-model": 4000},
+			maxPrompt:      100000,
+			reserve:        3000,
+			want:           2000,
+		},
+		{
+			name:            "tool definitions",
+			model:           "gpt-4o",
+			windows:         map[string]int{"gpt-4o": 8000},
+			maxPrompt:       100000,
+			reserve:         3000,
+			toolDefinitions: toolDefinitions,
+			want:            5000 - toolTokens,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.model = tt.model
+			cfg.fallbackModels = tt.fallbackModels
+			cfg.windows = tt.windows
+			cfg.maxPrompt = tt.maxPrompt
+			cfg.reserve = tt.reserve
+			c, _ := newTestChat(cfg, nil, tools.NewRegistry())
+
+			if got := c.promptBudget(tt.model, tt.toolDefinitions); got != tt.want {
+				t.Errorf("promptBudget() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountTurns(t *testing.T) {
+	messages := []conversation.Message{
+		conversation.NewMessage(openai.ChatMessageRoleUser, "question", "", ""),
+		conversation.NewToolCallMessage("", []conversation.ToolCall{{ID: "call_1", Name: "calculate"}}),
+		conversation.NewToolResultMessage("call_1", "2"),
+		conversation.NewMessage(openai.ChatMessageRoleAssistant, "answer", "", ""),
+	}
+
+	if got := countTurns(messages); got != 2 {
+		t.Errorf("countTurns() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,299 @@
+package usecase
+
+import (
+	"fmt"
+	"github.com/SGE-AI/sge-bot/config"
+	"github.com/SGE-AI/sge-bot/gpt"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"github.com/SGE-AI/sge-bot/slackapi"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGrantDuration - 期間を指定せずに上限を引き上げた場合の有効期間
+	DefaultGrantDuration = 24 * time.Hour
+
+	QuotaCommandUsage = "使い方: `quota grant @ユーザー <量> <tokens|usd> [期間 (例: 24h)]`"
+
+	OnQuotaGrantForbiddenMessage = "利用上限の引き上げは管理者のみ実行できます。"
+)
+
+type (
+	Quota interface {
+		// Reserve - ユーザー・チャンネル・全体の利用上限に達していないか確認し、回答の推定の使用量を集計に加算します
+		// 同時に届いた質問が上限を超えて回答されないよう、確認と加算は一度に行います。達している場合はその上限の情報を返します
+		Reserve(userID string, channelID string, estimate gpt.UsageRecord) (*QuotaReservation, *QuotaExceeded)
+
+		// Refine - 確保した推定の使用量を、より正確な見積もりに置き換えます (上限の確認は行いません)
+		Refine(reservation *QuotaReservation, estimate gpt.UsageRecord)
+
+		// Settle - 確保した推定の使用量を、回答で実際に使用したトークン数と料金に置き換えます
+		Settle(reservation *QuotaReservation, usage gpt.UsageRecord)
+
+		// Grant - 管理者がユーザーの利用上限を一時的に引き上げます
+		Grant(adminID string, userID string, metric string, amount float64, duration time.Duration) error
+
+		// HandleCommand - 管理者向けのコマンド (quota grant ...) であれば実行して返信します。コマンドでなければfalseを返します
+		HandleCommand(channelID string, threadTS string, userID string, text string) (bool, error)
+	}
+
+	// QuotaExceeded - 達している利用上限の情報
+	QuotaExceeded struct {
+		Quota   config.Quota
+		Used    float64
+		Limit   float64
+		ResetAt time.Time
+	}
+
+	// QuotaReservation - Reserveで集計に加算した推定の使用量
+	QuotaReservation struct {
+		keys     map[string]time.Time
+		estimate repository.QuotaUsage
+	}
+
+	quota struct {
+		// mu - 上限の確認と使用量の加算の間に、他の回答の使用量が加算されないようにします
+		mu sync.Mutex
+
+		slack  slackapi.SlackAPI
+		config config.Config
+		logger logger.Logger
+		repo   repository.QuotaRepository
+	}
+)
+
+var mentionPattern = regexp.MustCompile(`<@([A-Z0-9]+)(\|[^>]*)?>`)
+
+func (q *quota) Reserve(userID string, channelID string, estimate gpt.UsageRecord) (*QuotaReservation, *QuotaExceeded) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now().In(q.config.QuotaLocation())
+	reservation := &QuotaReservation{
+		keys:     make(map[string]time.Time),
+		estimate: newQuotaUsage(estimate),
+	}
+	for _, entry := range q.config.Quotas() {
+		key, resetAt := q.counterKey(entry, userID, channelID, now)
+		usage := q.repo.Usage(key)
+
+		limit := entry.Limit
+		if entry.Scope == config.QuotaScopeUser {
+			for _, grant := range q.repo.Grants(userID) {
+				if grant.Metric == entry.Metric {
+					limit += grant.Amount
+				}
+			}
+		}
+
+		used := float64(usage.Tokens)
+		if entry.Metric == config.QuotaMetricCost {
+			used = usage.Cost
+		}
+
+		if used >= limit {
+			q.logger.Log(logger.INFO, "quota exceeded: user_id=%s, channel_id=%s, quota=%s.%s.%s, used=%g, limit=%g",
+				userID, channelID, entry.Scope, entry.Period, entry.Metric, used, limit)
+			return nil, &QuotaExceeded{Quota: entry, Used: used, Limit: limit, ResetAt: resetAt}
+		}
+
+		// トークン数と料金は同じ集計に記録されるため、期間とスコープが同じ上限では一度だけ加算する
+		reservation.keys[key] = resetAt
+	}
+
+	q.addUsage(reservation.keys, reservation.estimate)
+	return reservation, nil
+}
+
+func (q *quota) Refine(reservation *QuotaReservation, estimate gpt.UsageRecord) {
+	if reservation == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	refined := newQuotaUsage(estimate)
+	q.addUsage(reservation.keys, repository.QuotaUsage{
+		Tokens: refined.Tokens - reservation.estimate.Tokens,
+		Cost:   refined.Cost - reservation.estimate.Cost,
+	})
+	reservation.estimate = refined
+}
+
+func (q *quota) Settle(reservation *QuotaReservation, usage gpt.UsageRecord) {
+	if reservation == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	actual := newQuotaUsage(usage)
+	q.addUsage(reservation.keys, repository.QuotaUsage{
+		Tokens: actual.Tokens - reservation.estimate.Tokens,
+		Cost:   actual.Cost - reservation.estimate.Cost,
+	})
+}
+
+func (q *quota) addUsage(keys map[string]time.Time, usage repository.QuotaUsage) {
+	if usage == (repository.QuotaUsage{}) {
+		return
+	}
+
+	for key, resetAt := range keys {
+		err := q.repo.AddUsage(key, resetAt, usage)
+		if err != nil {
+			q.logger.Log(logger.ERROR, "failed to save quota usage: key=%s, %v", key, err)
+		}
+	}
+}
+
+func newQuotaUsage(usage gpt.UsageRecord) repository.QuotaUsage {
+	return repository.QuotaUsage{
+		Tokens: usage.PromptTokens + usage.CompletionTokens,
+		Cost:   usage.Cost,
+	}
+}
+
+func (q *quota) Grant(adminID string, userID string, metric string, amount float64, duration time.Duration) error {
+	if !q.isAdmin(adminID) {
+		return fmt.Errorf("user %s is not an admin", adminID)
+	}
+	if metric != config.QuotaMetricTokens && metric != config.QuotaMetricCost {
+		return fmt.Errorf("unknown quota metric: %s", metric)
+	}
+	if amount <= 0 || duration <= 0 {
+		return fmt.Errorf("amount and duration must be positive")
+	}
+
+	err := q.repo.AddGrant(userID, repository.QuotaGrant{
+		Metric:    metric,
+		Amount:    amount,
+		GrantedBy: adminID,
+		ExpiresAt: time.Now().Add(duration),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save quota grant: %v", err)
+	}
+	q.logger.Log(logger.INFO, "quota granted: admin_id=%s, user_id=%s, metric=%s, amount=%g, duration=%s", adminID, userID, metric, amount, duration)
+	return nil
+}
+
+func (q *quota) HandleCommand(channelID string, threadTS string, userID string, text string) (bool, error) {
+	// ボット自身へのメンションを取り除いてからコマンドを解釈する
+	fields := strings.Fields(strings.Replace(text, "<@"+q.config.BotUserID()+">", "", 1))
+	if len(fields) < 2 || fields[0] != "quota" || fields[1] != "grant" {
+		return false, nil
+	}
+
+	if !q.isAdmin(userID) {
+		return true, q.slack.PostMessage(channelID, threadTS, OnQuotaGrantForbiddenMessage)
+	}
+
+	if len(fields) < 5 || !mentionPattern.MatchString(fields[2]) {
+		return true, q.slack.PostMessage(channelID, threadTS, QuotaCommandUsage)
+	}
+
+	target := mentionPattern.FindStringSubmatch(fields[2])[1]
+	amount, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return true, q.slack.PostMessage(channelID, threadTS, QuotaCommandUsage)
+	}
+	metric := fields[4]
+
+	duration := DefaultGrantDuration
+	if len(fields) >= 6 {
+		duration, err = time.ParseDuration(fields[5])
+		if err != nil {
+			return true, q.slack.PostMessage(channelID, threadTS, QuotaCommandUsage)
+		}
+	}
+
+	err = q.Grant(userID, target, metric, amount, duration)
+	if err != nil {
+		_ = q.slack.PostMessage(channelID, threadTS, fmt.Sprintf("利用上限を引き上げられませんでした: %v\n%s", err, QuotaCommandUsage))
+		return true, err
+	}
+
+	expiresAt := time.Now().Add(duration).In(q.config.QuotaLocation())
+	message := fmt.Sprintf(":white_check_mark: <@%s> の利用上限を %s 引き上げました (%s まで有効)",
+		target, formatQuotaAmount(metric, amount), expiresAt.Format("2006/01/02 15:04"))
+	return true, q.slack.PostMessage(channelID, threadTS, message)
+}
+
+// counterKey - 上限に対応する集計のキーと、集計がリセットされる時刻を求めます
+func (q *quota) counterKey(entry config.Quota, userID string, channelID string, now time.Time) (string, time.Time) {
+	var scopeID string
+	switch entry.Scope {
+	case config.QuotaScopeUser:
+		scopeID = userID
+	case config.QuotaScopeChannel:
+		scopeID = channelID
+	}
+
+	var period string
+	var resetAt time.Time
+	if entry.Period == config.QuotaPeriodMonthly {
+		period = now.Format("2006-01")
+		resetAt = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, 1, 0)
+	} else {
+		period = now.Format("2006-01-02")
+		resetAt = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1)
+	}
+
+	return fmt.Sprintf("%s:%s:%s", entry.Scope, scopeID, period), resetAt
+}
+
+func (q *quota) isAdmin(userID string) bool {
+	for _, id := range q.config.AdminUserIDs() {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Message - 利用上限に達したユーザーに表示するメッセージを作成します
+func (e *QuotaExceeded) Message() string {
+	scope := "あなた"
+	switch e.Quota.Scope {
+	case config.QuotaScopeChannel:
+		scope = "このチャンネル"
+	case config.QuotaScopeGlobal:
+		scope = "ボット全体"
+	}
+
+	period := "今日"
+	if e.Quota.Period == config.QuotaPeriodMonthly {
+		period = "今月"
+	}
+
+	message := fmt.Sprintf(":no_entry: %sの%sの利用上限 (%s) に達したため、回答できませんでした :bow:\n%s にリセットされます。",
+		scope, period, formatQuotaAmount(e.Quota.Metric, e.Limit), e.ResetAt.Format("2006/01/02 15:04"))
+	if e.Quota.Scope == config.QuotaScopeUser {
+		message += "お急ぎの場合は管理者に上限の引き上げをご相談ください。"
+	}
+	return message
+}
+
+func formatQuotaAmount(metric string, amount float64) string {
+	if metric == config.QuotaMetricCost {
+		return fmt.Sprintf("$%.2f", amount)
+	}
+	return fmt.Sprintf("%d トークン", int(amount))
+}
+
+func ProvideQuota(config config.Config, logger logger.Logger, api slackapi.SlackAPI, repo repository.QuotaRepository) Quota {
+	return &quota{
+		slack:  api,
+		config: config,
+		logger: logger,
+		repo:   repo,
+	}
+}
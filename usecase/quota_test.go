@@ -0,0 +1,145 @@
+package usecase
+
+import (
+	"github.com/SGE-AI/sge-bot/config"
+	"github.com/SGE-AI/sge-bot/gpt"
+	"github.com/SGE-AI/sge-bot/repository"
+	"sync"
+	"testing"
+	"time"
+)
+
+type quotaConfig struct {
+	config.Config
+	quotas []config.Quota
+}
+
+func (c *quotaConfig) Quotas() []config.Quota                    { return c.quotas }
+func (c *quotaConfig) QuotaLocation() *time.Location             { return time.UTC }
+func (c *quotaConfig) AdminUserIDs() []string                    { return []string{"UADMIN"} }
+func (c *quotaConfig) ModelPrices() map[string]config.ModelPrice { return nil }
+
+func newTestQuota(quotas ...config.Quota) (Quota, repository.QuotaRepository) {
+	repo := repository.NewInMemoryQuotaRepository()
+	return ProvideQuota(&quotaConfig{quotas: quotas}, nopLogger{}, nil, repo), repo
+}
+
+func userTokens(limit float64) config.Quota {
+	return config.Quota{Scope: config.QuotaScopeUser, Period: config.QuotaPeriodDaily, Metric: config.QuotaMetricTokens, Limit: limit}
+}
+
+func todayKey(userID string) string {
+	return "user:" + userID + ":" + time.Now().UTC().Format("2006-01-02")
+}
+
+func TestQuotaReserveConcurrent(t *testing.T) {
+	q, repo := newTestQuota(userTokens(1000))
+	estimate := gpt.UsageRecord{PromptTokens: 400, CompletionTokens: 200}
+
+	// 上限の確認と加算が一度に行われるため、同時に届いても上限を超えて確保されるのは最初の一回だけ
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reserved []*QuotaReservation
+	exceeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reservation, over := q.Reserve("U1", "C1", estimate)
+			mu.Lock()
+			defer mu.Unlock()
+			if over != nil {
+				exceeded++
+				return
+			}
+			reserved = append(reserved, reservation)
+		}()
+	}
+	wg.Wait()
+
+	if len(reserved) != 2 || exceeded != 18 {
+		t.Fatalf("reserved = %d, exceeded = %d, want 2 and 18", len(reserved), exceeded)
+	}
+	if got := repo.Usage(todayKey("U1")).Tokens; got != 1200 {
+		t.Errorf("reserved tokens = %d, want 1200", got)
+	}
+
+	// 見積もり直すと、前の見積もりとの差だけ集計が変わる
+	q.Refine(reserved[0], gpt.UsageRecord{PromptTokens: 500, CompletionTokens: 200})
+	if got := repo.Usage(todayKey("U1")).Tokens; got != 1300 {
+		t.Errorf("refined tokens = %d, want 1300", got)
+	}
+
+	// 実際の使用量に置き換えると、見積もりとの差だけ集計が減る
+	q.Settle(reserved[0], gpt.UsageRecord{PromptTokens: 100, CompletionTokens: 50})
+	q.Settle(reserved[1], gpt.UsageRecord{})
+	if got := repo.Usage(todayKey("U1")).Tokens; got != 150 {
+		t.Errorf("settled tokens = %d, want 150", got)
+	}
+
+	if _, over := q.Reserve("U1", "C1", estimate); over != nil {
+		t.Errorf("Reserve() after settle exceeded: %+v", over)
+	}
+}
+
+func TestQuotaReserve(t *testing.T) {
+	channelCost := config.Quota{Scope: config.QuotaScopeChannel, Period: config.QuotaPeriodMonthly, Metric: config.QuotaMetricCost, Limit: 1}
+
+	tests := []struct {
+		name       string
+		quotas     []config.Quota
+		used       repository.QuotaUsage
+		grant      float64
+		wantExceed bool
+	}{
+		{
+			name: "no quota",
+			used: repository.QuotaUsage{Tokens: 1_000_000},
+		},
+		{
+			name:   "under limit",
+			quotas: []config.Quota{userTokens(1000), channelCost},
+			used:   repository.QuotaUsage{Tokens: 999},
+		},
+		{
+			name:       "at limit",
+			quotas:     []config.Quota{userTokens(1000), channelCost},
+			used:       repository.QuotaUsage{Tokens: 1000},
+			wantExceed: true,
+		},
+		{
+			name:   "granted",
+			quotas: []config.Quota{userTokens(1000)},
+			used:   repository.QuotaUsage{Tokens: 1000},
+			grant:  500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, repo := newTestQuota(tt.quotas...)
+			_ = repo.AddUsage(todayKey("U1"), time.Now().Add(time.Hour), tt.used)
+			if tt.grant > 0 {
+				err := q.Grant("UADMIN", "U1", config.QuotaMetricTokens, tt.grant, time.Hour)
+				if err != nil {
+					t.Fatalf("Grant() error = %v", err)
+				}
+			}
+
+			reservation, exceeded := q.Reserve("U1", "C1", gpt.UsageRecord{PromptTokens: 10})
+			if !tt.wantExceed {
+				if exceeded != nil || reservation == nil {
+					t.Fatalf("Reserve() exceeded = %+v", exceeded)
+				}
+				return
+			}
+
+			if exceeded == nil || exceeded.Quota != userTokens(1000) {
+				t.Fatalf("Reserve() exceeded = %+v", exceeded)
+			}
+			if got := repo.Usage(todayKey("U1")); got != tt.used {
+				t.Errorf("usage after exceeded = %v, want %v", got, tt.used)
+			}
+		})
+	}
+}
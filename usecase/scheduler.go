@@ -0,0 +1,205 @@
+package usecase
+
+import (
+	"context"
+	"github.com/SGE-AI/sge-bot/config"
+	"sync"
+)
+
+type (
+	// Scheduler - OpenAIへのリクエストの同時実行数を制限し、待っているリクエストをユーザー間で公平に順番に実行します
+	Scheduler interface {
+		// Acquire - 実行枠が空くまで待ちます。待っている間は、自分より前に待っているリクエストの数が変わるたびにonWaitを呼び出します
+		// 実行が終わったら、返されたreleaseを必ず呼び出してください
+		Acquire(ctx context.Context, userID string, onWait func(ahead int)) (release func(), err error)
+	}
+
+	scheduler struct {
+		mu sync.Mutex
+
+		maxConcurrent int
+		maxPerUser    int
+
+		running       int
+		runningByUser map[string]int
+
+		// queues - ユーザーごとの待ち行列
+		queues map[string][]*waiter
+
+		// users - 待っているリクエストがあるユーザーを、順番に実行枠を割り当てる順で並べたもの
+		users []string
+		next  int
+	}
+
+	waiter struct {
+		userID  string
+		granted bool
+		ready   chan struct{}
+
+		// ahead - 前に待っているリクエストの数 (最新の値だけを保持します)
+		ahead chan int
+	}
+)
+
+func (s *scheduler) Acquire(ctx context.Context, userID string, onWait func(ahead int)) (func(), error) {
+	w := &waiter{
+		userID: userID,
+		ready:  make(chan struct{}),
+		ahead:  make(chan int, 1),
+	}
+
+	s.mu.Lock()
+	if len(s.queues[userID]) == 0 {
+		s.users = append(s.users, userID)
+	}
+	s.queues[userID] = append(s.queues[userID], w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := s.releaseFunc(userID)
+	for {
+		select {
+		case <-w.ready:
+			return release, nil
+		case ahead := <-w.ahead:
+			onWait(ahead)
+		case <-ctx.Done():
+			s.mu.Lock()
+			granted := w.granted
+			if !granted {
+				s.remove(w)
+				s.notifyPositions()
+			}
+			s.mu.Unlock()
+
+			// キャンセルと同時に実行枠が割り当てられた場合は返却する
+			if granted {
+				release()
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *scheduler) releaseFunc(userID string) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			s.running--
+			s.runningByUser[userID]--
+			if s.runningByUser[userID] <= 0 {
+				delete(s.runningByUser, userID)
+			}
+			s.dispatch()
+		})
+	}
+}
+
+// dispatch - 空いている実行枠を、ユーザーを順番に巡りながら待っているリクエストに割り当てます
+func (s *scheduler) dispatch() {
+	for s.running < s.maxConcurrent && len(s.users) > 0 {
+		index := -1
+		for i := 0; i < len(s.users); i++ {
+			candidate := (s.next + i) % len(s.users)
+			if s.runningByUser[s.users[candidate]] < s.maxPerUser {
+				index = candidate
+				break
+			}
+		}
+		if index < 0 {
+			break
+		}
+
+		userID := s.users[index]
+		w := s.queues[userID][0]
+		s.queues[userID] = s.queues[userID][1:]
+		if len(s.queues[userID]) == 0 {
+			delete(s.queues, userID)
+			s.users = append(s.users[:index], s.users[index+1:]...)
+			s.next = index
+		} else {
+			s.next = index + 1
+		}
+		if len(s.users) > 0 {
+			s.next %= len(s.users)
+		} else {
+			s.next = 0
+		}
+
+		s.running++
+		s.runningByUser[userID]++
+		w.granted = true
+		close(w.ready)
+	}
+
+	s.notifyPositions()
+}
+
+// remove - 待ち行列からリクエストを取り除きます
+func (s *scheduler) remove(w *waiter) {
+	queue := s.queues[w.userID]
+	for i, candidate := range queue {
+		if candidate == w {
+			s.queues[w.userID] = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(s.queues[w.userID]) > 0 {
+		return
+	}
+
+	delete(s.queues, w.userID)
+	for i, userID := range s.users {
+		if userID == w.userID {
+			s.users = append(s.users[:i], s.users[i+1:]...)
+			if i < s.next {
+				s.next--
+			}
+			break
+		}
+	}
+	if len(s.users) > 0 {
+		s.next %= len(s.users)
+	} else {
+		s.next = 0
+	}
+}
+
+// notifyPositions - 待っているリクエストに、前に待っているリクエストの数を通知します
+// ユーザーごとの同時実行数の制限は考慮せず、ユーザーを順番に巡った場合の順番で数えます
+func (s *scheduler) notifyPositions() {
+	ahead := 0
+	for depth := 0; ; depth++ {
+		found := false
+		for i := 0; i < len(s.users); i++ {
+			queue := s.queues[s.users[(s.next+i)%len(s.users)]]
+			if depth >= len(queue) {
+				continue
+			}
+			found = true
+
+			w := queue[depth]
+			select {
+			case <-w.ahead:
+			default:
+			}
+			w.ahead <- ahead
+			ahead++
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+func ProvideScheduler(config config.Config) Scheduler {
+	return &scheduler{
+		maxConcurrent: config.MaxConcurrentRequests(),
+		maxPerUser:    config.MaxConcurrentRequestsPerUser(),
+		runningByUser: make(map[string]int),
+		queues:        make(map[string][]*waiter),
+	}
+}
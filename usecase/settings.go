@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SGE-AI/sge-bot/config"
+	"github.com/SGE-AI/sge-bot/logger"
+	"github.com/SGE-AI/sge-bot/repository"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxPersonalInstructionsLength - 個人的な指示の最大文字数
+	MaxPersonalInstructionsLength = 2000
+)
+
+var (
+	// ErrInstructionsTooLong - 個人的な指示が長すぎる
+	ErrInstructionsTooLong = errors.New("instructions too long")
+
+	// ErrInvalidSettings - 選択肢にない値が設定された
+	ErrInvalidSettings = errors.New("invalid settings")
+)
+
+type (
+	// Settings - ユーザーごとの個人設定を管理します
+	Settings interface {
+		Get(userID string) repository.UserSettings
+
+		// Update - 設定を検証してから保存します
+		Update(userID string, settings repository.UserSettings) error
+
+		// Model - ユーザーが既定で利用するモデルを返します。設定されていないか、利用できなくなったモデルの場合は空を返します
+		Model(userID string) string
+
+		// Instructions - 言語・回答のスタイル・個人的な指示を、システムメッセージに埋め込む指示にまとめます
+		Instructions(userID string) string
+	}
+
+	// SettingChoice - 個人設定の選択肢
+	SettingChoice struct {
+		Value string
+		Label string
+
+		// Instruction - 選択された場合にモデルへ伝える指示
+		Instruction string
+	}
+
+	settings struct {
+		config config.Config
+		logger logger.Logger
+		repo   repository.UserSettingsRepository
+	}
+)
+
+// Languages - 回答に使う言語の選択肢
+var Languages = []SettingChoice{
+	{Value: "ja", Label: "日本語", Instruction: "質問の言語に関わらず、日本語で回答してください。"},
+	{Value: "en", Label: "English", Instruction: "Always answer in English, regardless of the language of the question."},
+}
+
+// AnswerStyles - 回答のスタイルの選択肢
+var AnswerStyles = []SettingChoice{
+	{Value: "concise", Label: "簡潔に", Instruction: "前置きを省き、結論を先に、できるだけ短く回答してください。"},
+	{Value: "detailed", Label: "詳しく", Instruction: "背景や理由、具体例を含めて詳しく回答してください。"},
+	{Value: "beginner", Label: "初心者向けに", Instruction: "専門用語はかみ砕いて説明し、初心者にも分かるように回答してください。"},
+}
+
+// Temperatures - 回答のランダム性の選択肢
+var Temperatures = []SettingChoice{
+	{Value: "0.2", Label: "0.2 (正確さ重視)"},
+	{Value: "0.7", Label: "0.7"},
+	{Value: "1.0", Label: "1.0"},
+	{Value: "1.3", Label: "1.3 (創造性重視)"},
+}
+
+func (s settings) Get(userID string) repository.UserSettings {
+	return s.repo.Load(userID)
+}
+
+func (s settings) Update(userID string, settings repository.UserSettings) error {
+	if settings.Model != "" && !isAllowedModel(s.config, settings.Model) {
+		return fmt.Errorf("%w: %s", ErrModelNotAllowed, settings.Model)
+	}
+	if settings.Language != "" && !hasChoice(Languages, settings.Language) {
+		return fmt.Errorf("%w: language %s", ErrInvalidSettings, settings.Language)
+	}
+	if settings.AnswerStyle != "" && !hasChoice(AnswerStyles, settings.AnswerStyle) {
+		return fmt.Errorf("%w: answer style %s", ErrInvalidSettings, settings.AnswerStyle)
+	}
+	if settings.Temperature != nil && (*settings.Temperature < 0 || *settings.Temperature > 2) {
+		return fmt.Errorf("%w: temperature %g", ErrInvalidSettings, *settings.Temperature)
+	}
+	if utf8.RuneCountInString(settings.Instructions) > MaxPersonalInstructionsLength {
+		return ErrInstructionsTooLong
+	}
+
+	err := s.repo.Save(userID, settings)
+	if err != nil {
+		return fmt.Errorf("failed to save user settings: %v", err)
+	}
+	s.logger.Log(logger.INFO, "user settings updated: user_id=%s, model=%s, language=%s, answer_style=%s",
+		userID, settings.Model, settings.Language, settings.AnswerStyle)
+	return nil
+}
+
+func (s settings) Model(userID string) string {
+	model := s.repo.Load(userID).Model
+	if model == "" || !isAllowedModel(s.config, model) {
+		return ""
+	}
+	return model
+}
+
+func (s settings) Instructions(userID string) string {
+	settings := s.repo.Load(userID)
+
+	var instructions []string
+	if choice, ok := findChoice(Languages, settings.Language); ok {
+		instructions = append(instructions, choice.Instruction)
+	}
+	if choice, ok := findChoice(AnswerStyles, settings.AnswerStyle); ok {
+		instructions = append(instructions, choice.Instruction)
+	}
+	if settings.Instructions != "" {
+		instructions = append(instructions, settings.Instructions)
+	}
+	return strings.Join(instructions, "\n")
+}
+
+// isAllowedModel - 利用が許可されているモデルかどうかを判定します
+func isAllowedModel(config config.Config, model string) bool {
+	for _, allowed := range config.AllowedModels() {
+		if allowed == model {
+			return true
+		}
+	}
+	return false
+}
+
+func findChoice(choices []SettingChoice, value string) (SettingChoice, bool) {
+	for _, choice := range choices {
+		if choice.Value == value {
+			return choice, true
+		}
+	}
+	return SettingChoice{}, false
+}
+
+func hasChoice(choices []SettingChoice, value string) bool {
+	_, ok := findChoice(choices, value)
+	return ok
+}
+
+func ProvideSettings(config config.Config, logger logger.Logger, repo repository.UserSettingsRepository) Settings {
+	return &settings{
+		config: config,
+		logger: logger,
+		repo:   repo,
+	}
+}
@@ -1,18 +1,36 @@
 package usecase
 
 import (
+	"errors"
+	"github.com/SGE-AI/sge-bot/gpt"
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/SGE-AI/sge-bot/repository"
+	"sync"
 	"time"
 )
 
+// ErrStatisticsDisabled - スプレッドシートが設定されておらず、統計情報を記録していない
+var ErrStatisticsDisabled = errors.New("statistics is disabled")
+
 type Statistics interface {
 	UsedBy(SlackUserID string)
+
+	// Get - ユーザーの統計情報を取得します
+	Get(SlackUserID string) (repository.UserStatistics, error)
+
+	// RecordModel - 実際に応答したモデルを記録します
+	RecordModel(SlackUserID string, model string)
+
+	// RecordUsage - 一回の回答で使用したトークン数と料金を累計に加算します
+	RecordUsage(SlackUserID string, usage gpt.UsageRecord)
 }
 
 type statistics struct {
 	spreadSheetRepo repository.SpreadsheetRepository
 	log             logger.Logger
+
+	// スプレッドシートの読み込みから書き込みまでの間に他の更新が割り込まないようにする
+	mu sync.Mutex
 }
 
 func (s *statistics) UsedBy(SlackUserID string) {
@@ -20,6 +38,9 @@ func (s *statistics) UsedBy(SlackUserID string) {
 		return
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	stat, err := s.spreadSheetRepo.Get(SlackUserID)
 	if err != nil {
 		s.log.Log(logger.ERROR, "failed to get statistics: %v", err)
@@ -38,6 +59,65 @@ func (s *statistics) UsedBy(SlackUserID string) {
 	s.log.Log(logger.INFO, "update statistics: user_id=%s, use_count=%d, last_used=%s", stat.SlackUserID, stat.UseCount, stat.LastUsed)
 }
 
+func (s *statistics) Get(SlackUserID string) (repository.UserStatistics, error) {
+	if s.spreadSheetRepo == nil {
+		return repository.UserStatistics{}, ErrStatisticsDisabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.spreadSheetRepo.Get(SlackUserID)
+}
+
+func (s *statistics) RecordModel(SlackUserID string, model string) {
+	if s.spreadSheetRepo == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat, err := s.spreadSheetRepo.Get(SlackUserID)
+	if err != nil {
+		s.log.Log(logger.ERROR, "failed to get statistics: %v", err)
+		return
+	}
+
+	stat.LastModel = model
+	err = s.spreadSheetRepo.Update(stat)
+	if err != nil {
+		s.log.Log(logger.ERROR, "failed to update statistics: %v", err)
+		return
+	}
+	s.log.Log(logger.INFO, "update statistics: user_id=%s, last_model=%s", stat.SlackUserID, stat.LastModel)
+}
+
+func (s *statistics) RecordUsage(SlackUserID string, usage gpt.UsageRecord) {
+	if s.spreadSheetRepo == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat, err := s.spreadSheetRepo.Get(SlackUserID)
+	if err != nil {
+		s.log.Log(logger.ERROR, "failed to get statistics: %v", err)
+		return
+	}
+
+	stat.PromptTokens += usage.PromptTokens
+	stat.CompletionTokens += usage.CompletionTokens
+	stat.Cost += usage.Cost
+	err = s.spreadSheetRepo.Update(stat)
+	if err != nil {
+		s.log.Log(logger.ERROR, "failed to update statistics: %v", err)
+		return
+	}
+	s.log.Log(logger.INFO, "update statistics: user_id=%s, prompt_tokens=%d, completion_tokens=%d, cost=$%.6f", stat.SlackUserID, stat.PromptTokens, stat.CompletionTokens, stat.Cost)
+}
+
 func ProvideStatistics(spreadSheetRepo repository.SpreadsheetRepository, log logger.Logger) Statistics {
 	return &statistics{
 		spreadSheetRepo: spreadSheetRepo,
@@ -0,0 +1,70 @@
+package usecase
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// threadLocks - スレッドごとのロック。同じスレッドで後から来たリクエストは、先に来たリクエストの完了を待ちます
+	threadLocks struct {
+		mu    sync.Mutex
+		locks map[string]*threadLock
+	}
+
+	threadLock struct {
+		// slot - 容量1のチャネルへの送信でロックを取得します (待っている順に取得できます)
+		slot chan struct{}
+
+		// refs - ロックを保持・待機しているリクエストの数 (0になったら破棄します)
+		refs int
+	}
+)
+
+// acquire - スレッドのロックを取得します。他のリクエストが保持していて待つ必要がある場合はonWaitを一度呼び出します
+func (t *threadLocks) acquire(ctx context.Context, key string, onWait func()) (func(), error) {
+	t.mu.Lock()
+	lock, ok := t.locks[key]
+	if !ok {
+		lock = &threadLock{slot: make(chan struct{}, 1)}
+		t.locks[key] = lock
+	}
+	lock.refs++
+	t.mu.Unlock()
+
+	release := func() {
+		<-lock.slot
+		t.unref(key, lock)
+	}
+
+	select {
+	case lock.slot <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	onWait()
+	select {
+	case lock.slot <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		t.unref(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+func (t *threadLocks) unref(key string, lock *threadLock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(t.locks, key)
+	}
+}
+
+func newThreadLocks() *threadLocks {
+	return &threadLocks{
+		locks: make(map[string]*threadLock),
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/SGE-AI/sge-bot/repository"
 	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/tools"
 	"github.com/SGE-AI/sge-bot/usecase"
 	"github.com/google/wire"
 )
@@ -20,9 +21,17 @@ func initializeApp() *Application {
 		logger.ProvideLogger,
 		repository.ProvideContextCancelRepository,
 		repository.ProvideSpreadsheetRepository,
+		repository.ProvideSummaryRepository,
+		repository.ProvideQuotaRepository,
+		repository.ProvideEventRepository,
+		repository.ProvideUserSettingsRepository,
 		gpt.ProvideGPTClient,
+		tools.ProvideRegistry,
 		usecase.ProvideChat,
 		usecase.ProvideStatistics,
+		usecase.ProvideQuota,
+		usecase.ProvideScheduler,
+		usecase.ProvideSettings,
 		interfaces.ProvideEventHandler,
 		interfaces.ProvideSocketConnection,
 		slackapi.ProvideSlackAPI,
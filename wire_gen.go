@@ -13,6 +13,7 @@ import (
 	"github.com/SGE-AI/sge-bot/logger"
 	"github.com/SGE-AI/sge-bot/repository"
 	"github.com/SGE-AI/sge-bot/slackapi"
+	"github.com/SGE-AI/sge-bot/tools"
 	"github.com/SGE-AI/sge-bot/usecase"
 )
 
@@ -24,11 +25,19 @@ func initializeApp() *Application {
 	client := gpt.ProvideGPTClient(configConfig, loggerLogger)
 	slackAPI := slackapi.ProvideSlackAPI(configConfig, loggerLogger)
 	contextCancelRepository := repository.ProvideContextCancelRepository()
-	chat := usecase.ProvideChat(client, configConfig, loggerLogger, slackAPI, contextCancelRepository)
 	spreadsheetRepository := repository.ProvideSpreadsheetRepository(configConfig, loggerLogger)
 	statistics := usecase.ProvideStatistics(spreadsheetRepository, loggerLogger)
-	eventHandler := interfaces.ProvideEventHandler(configConfig, loggerLogger, chat, statistics)
-	socketConnection := interfaces.ProvideSocketConnection(configConfig, eventHandler, loggerLogger)
-	application := ProvideApplication(configConfig, loggerLogger, socketConnection, slackAPI)
+	registry := tools.ProvideRegistry(slackAPI, loggerLogger)
+	summaryRepository := repository.ProvideSummaryRepository()
+	quotaRepository := repository.ProvideQuotaRepository(configConfig)
+	quota := usecase.ProvideQuota(configConfig, loggerLogger, slackAPI, quotaRepository)
+	scheduler := usecase.ProvideScheduler(configConfig)
+	userSettingsRepository := repository.ProvideUserSettingsRepository(configConfig)
+	settings := usecase.ProvideSettings(configConfig, loggerLogger, userSettingsRepository)
+	chat := usecase.ProvideChat(client, configConfig, loggerLogger, slackAPI, contextCancelRepository, summaryRepository, statistics, quota, scheduler, registry, settings)
+	eventRepository := repository.ProvideEventRepository()
+	eventHandler := interfaces.ProvideEventHandler(configConfig, loggerLogger, chat, statistics, quota, settings, eventRepository, slackAPI)
+	socketConnection := interfaces.ProvideSocketConnection(configConfig, eventHandler, loggerLogger, eventRepository)
+	application := ProvideApplication(configConfig, loggerLogger, socketConnection, slackAPI, client)
 	return application
 }